@@ -17,6 +17,10 @@ var (
 
 func init() {
 	var noUpdate bool
+	var locked bool
+	var offline bool
+	var jobs int
+	var allowOverlap bool
 
 	var buildCmd = &cobra.Command{
 		Use:   "build",
@@ -24,11 +28,10 @@ func init() {
 		Run: func(cmd *cobra.Command, args []string) {
 			projPath := "."
 
-			if !noUpdate {
-				if err := doUpdate(projPath); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-					os.Exit(1)
-				}
+			opts := proj.UpdateOptions{Frozen: locked, Offline: offline, Jobs: jobs, AllowOverlap: allowOverlap}
+			if err := doUpdateOrCheckOverlaps(projPath, noUpdate, opts); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
 			}
 
 			if err := doBuild(projPath, args); err != nil {
@@ -39,6 +42,10 @@ func init() {
 	}
 
 	addNoUpdateFlag(buildCmd, &noUpdate)
+	addLockedFlag(buildCmd, &locked)
+	addOfflineFlag(buildCmd, &offline)
+	addJobsFlag(buildCmd, &jobs)
+	addAllowOverlapFlag(buildCmd, &allowOverlap)
 	RootCommand.AddCommand(buildCmd)
 }
 
@@ -51,6 +58,10 @@ func doBuild(projPath string, args []string) error {
 		return err
 	}
 
+	if err := project.CompileSources(); err != nil {
+		return err
+	}
+
 	outputDir, outputFile := filepath.Split(project.Build.Output)
 	if outputFile == "" {
 		outputFile = defaultTargetFile
@@ -79,7 +90,7 @@ func doBuild(projPath string, args []string) error {
 		WithEntrypoints(project.Build.Entrypoints).
 		WithTarget(project.Build.Target)
 	if output, err := opa.Build(outputPath, args...); err != nil {
-		return fmt.Errorf("error running opa eval:\n %s", err)
+		return fmt.Errorf("error running opa eval:\n %s", project.SourcePaths(err.Error()))
 	} else {
 		printer.Info(output)
 	}
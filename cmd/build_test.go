@@ -87,7 +87,7 @@ func TestBuildProjects(t *testing.T) {
 			output := bytes.Buffer{}
 			printer.PrintWriter = &output
 			args := []string{}
-			if err := doUpdate(tc.projectDir); err != nil {
+			if err := doUpdate(tc.projectDir, proj.UpdateOptions{}); err != nil {
 				t.Fatal(err)
 			}
 			if err := doBuild(tc.projectDir, args); err != nil {
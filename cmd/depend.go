@@ -11,6 +11,7 @@ import (
 func init() {
 	var namespace string
 	var noNamespace bool
+	var sha256 string
 
 	var depCommand = &cobra.Command{
 		Use:   "depend <name> <location> [flags]",
@@ -20,6 +21,11 @@ func init() {
 Supported location types:
 - Git repository: git+http://..., git+https://..., git+ssh://...
 - Local file/directory: file://path/to/dir, file:/../path/to/dir
+- Archive: http://..., https://..., ending in .tar.gz, .tgz, or .zip; verified against --sha256, if set
+- OPA bundle: bundle+file:/path/to/bundle.tar.gz, bundle+https://...; verified against --sha256, if set
+- OCI artifact: oci://registry/repository:tag, oci://registry/repository@sha256:...
+- Registry package: a version constraint, e.g. ^1.0, on the package of the same name in the registry
+  declared in opa.project; or registry:package[@version]
 
 Example:`,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -40,7 +46,7 @@ Example:`,
 				namespace = name
 			}
 
-			if err := doAddDependency(name, location, namespace, projPath); err != nil {
+			if err := doAddDependency(name, location, namespace, sha256, projPath); err != nil {
 				_, _ = cmd.OutOrStderr().Write([]byte(err.Error()))
 				os.Exit(1)
 			}
@@ -49,11 +55,12 @@ Example:`,
 
 	depCommand.Flags().StringVarP(&namespace, "namespace", "n", "", "namespace of the dependency. Ignored if --no-namespace is set")
 	depCommand.Flags().BoolVar(&noNamespace, "no-namespace", false, "")
+	depCommand.Flags().StringVar(&sha256, "sha256", "", "sha256 checksum of an archive dependency")
 
 	RootCommand.AddCommand(depCommand)
 }
 
-func doAddDependency(name string, location string, namespace string, projectPath string) error {
+func doAddDependency(name string, location string, namespace string, sha256 string, projectPath string) error {
 	printer.Trace("--- Dep start ---")
 	defer printer.Trace("--- Dep end ---")
 
@@ -70,12 +77,13 @@ func doAddDependency(name string, location string, namespace string, projectPath
 		return err
 	}
 
-	dependency := proj.DependencyInfo{
-		Namespace: namespace,
-		Location:  location,
-	}
+	dependency := proj.ShortFormDependency(name, location)
+	dependency.Namespace = namespace
+	dependency.Sha256 = sha256
 
-	project.SetDependency(name, dependency)
+	if err := project.SetDependency(name, dependency); err != nil {
+		return err
+	}
 
 	return project.WriteToFile(projectPath, true)
 }
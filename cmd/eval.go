@@ -11,6 +11,10 @@ import (
 
 func init() {
 	var noUpdate bool
+	var locked bool
+	var offline bool
+	var jobs int
+	var allowOverlap bool
 
 	var evalCommand = &cobra.Command{
 		Use:   "eval [flags] -- [opa eval flags]",
@@ -26,11 +30,10 @@ Example:
 		Run: func(cmd *cobra.Command, args []string) {
 			projPath := "."
 
-			if !noUpdate {
-				if err := doUpdate(projPath); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-					os.Exit(1)
-				}
+			opts := proj.UpdateOptions{Frozen: locked, Offline: offline, Jobs: jobs, AllowOverlap: allowOverlap}
+			if err := doUpdateOrCheckOverlaps(projPath, noUpdate, opts); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
 			}
 
 			if err := doEval(projPath, args); err != nil {
@@ -41,6 +44,10 @@ Example:
 	}
 
 	addNoUpdateFlag(evalCommand, &noUpdate)
+	addLockedFlag(evalCommand, &locked)
+	addOfflineFlag(evalCommand, &offline)
+	addJobsFlag(evalCommand, &jobs)
+	addAllowOverlapFlag(evalCommand, &allowOverlap)
 	RootCommand.AddCommand(evalCommand)
 }
 
@@ -58,6 +65,10 @@ func doEval(projPath string, args []string) error {
 		return err
 	}
 
+	if err := project.CompileSources(); err != nil {
+		return err
+	}
+
 	dataLocations, err := project.DataLocations()
 	if err != nil {
 		return fmt.Errorf("error getting data locations: %s", err)
@@ -65,9 +76,9 @@ func doEval(projPath string, args []string) error {
 
 	opa := utils.NewOpa(dataLocations...)
 	if output, err := opa.Eval(args...); err != nil {
-		return fmt.Errorf("error running opa eval:\n %s", err)
+		return fmt.Errorf("error running opa eval:\n %s", project.SourcePaths(err.Error()))
 	} else {
-		printer.Output(output)
+		printer.Output(project.SourcePaths(output))
 	}
 
 	return nil
@@ -3,6 +3,8 @@ package cmd
 import (
 	"bytes"
 	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -105,6 +107,41 @@ func TestEvalProjects(t *testing.T) {
       }
     }
   }
+}`,
+		},
+		{
+			name:       "Project with namespaced data dependency",
+			projectDir: filepath.Join(rootDir, "testdata", "projects", "data-dependencies"),
+			query:      "x := data",
+			expectedOutput: `{
+  "x": {
+    "main": {
+      "allow": true
+    },
+    "sl": {
+      "do": {
+        "re": {
+          "mi": "fa"
+        }
+      },
+      "foo": {
+        "bar": "baz"
+      },
+      "test": {
+        "allow": true
+      }
+    }
+  }
+}`,
+		},
+		{
+			name:       "Project with imported dependency",
+			projectDir: filepath.Join(rootDir, "testdata", "projects", "imported-dependencies"),
+			query:      "x := data.main",
+			expectedOutput: `{
+  "x": {
+    "allow": true
+  }
 }`,
 		},
 	}
@@ -117,7 +154,7 @@ func TestEvalProjects(t *testing.T) {
 			output := bytes.Buffer{}
 			printer.PrintWriter = &output
 			args := []string{tc.query, "--format", "bindings"}
-			if err := doUpdate(tc.projectDir); err != nil {
+			if err := doUpdate(tc.projectDir, proj.UpdateOptions{}); err != nil {
 				t.Fatal(err)
 			}
 			if err := doEval(tc.projectDir, args); err != nil {
@@ -129,3 +166,64 @@ func TestEvalProjects(t *testing.T) {
 		})
 	}
 }
+
+func TestEvalNoUpdateChecksOverlaps(t *testing.T) {
+	tests := []struct {
+		note         string
+		allowOverlap bool
+		expectedErr  string
+	}{
+		{
+			note:        "overlap",
+			expectedErr: "dependencies overlap in data",
+		},
+		{
+			note:         "allowed overlap",
+			allowOverlap: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"opa.project":     "source: src\ndependencies:\n  a: file:/a\n",
+				"src/policy.rego": "package a.x\n\np := 1\n",
+				"a/policy.rego":   "package x\n\np := 2\n",
+			}
+			for file, content := range files {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var log bytes.Buffer
+			printer.LogWriter = &log
+			defer func() {
+				printer.LogWriter = os.Stderr
+			}()
+
+			if err := doUpdate(dir, proj.UpdateOptions{AllowOverlap: true}); err != nil {
+				t.Fatal(err)
+			}
+			log.Reset()
+
+			err := doUpdateOrCheckOverlaps(dir, true, proj.UpdateOptions{AllowOverlap: tc.allowOverlap})
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(log.String(), "Warning") {
+				t.Fatalf("expected overlap warning, got:\n%s", log.String())
+			}
+		})
+	}
+}
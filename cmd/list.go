@@ -11,6 +11,7 @@ import (
 
 func init() {
 	var noUpdate bool
+	var offline bool
 	var includeTestDirs bool
 	var includeDepTests bool
 
@@ -29,7 +30,7 @@ func init() {
 			projPath := "."
 
 			if !noUpdate {
-				if err := doUpdate(projPath); err != nil {
+				if err := doUpdate(projPath, proj.UpdateOptions{Offline: offline}); err != nil {
 					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 					os.Exit(1)
 				}
@@ -44,6 +45,7 @@ func init() {
 
 	listSourceCommand.Flags().BoolVarP(&includeTestDirs, "include-test-dirs", "t", false, "Include test directories in the list")
 	listSourceCommand.Flags().BoolVar(&includeDepTests, "include-dep-tests", false, "Include dependency tests")
+	addOfflineFlag(listSourceCommand, &offline)
 	listCommand.AddCommand(listSourceCommand)
 }
 
@@ -56,6 +58,10 @@ func doListSource(projPath string, includeTestDirs, includeDepTests bool) error
 		return err
 	}
 
+	if err := project.CompileSources(); err != nil {
+		return err
+	}
+
 	dataLocations, err := project.DataLocations()
 	if err != nil {
 		return fmt.Errorf("error getting data locations: %s", err)
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep tests from reading or writing the user-level dependency cache
+	dir, err := os.MkdirTemp("", "odm-cache-")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_ = os.Setenv("ODM_CACHE_DIR", dir)
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
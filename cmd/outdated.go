@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	var update bool
+	var offline bool
+	var jsonOutput bool
+
+	var outdatedCommand = &cobra.Command{
+		Use:   "outdated",
+		Short: "List available versions of git dependencies",
+		Long: `List available versions of git dependencies
+
+For every git dependency, including transitive dependencies, the ref it's currently locked at is listed,
+together with the newest tag matching its declared version constraint, if any, and the newest tag overall.
+Only tags that are semantic versions are considered; pre-releases are excluded from the newest tag overall.
+Dependencies are reported as last updated, unless --update is given.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			if update {
+				if err := doUpdate(projPath, proj.UpdateOptions{Offline: offline}); err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := doOutdated(projPath, offline, jsonOutput); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	outdatedCommand.Flags().BoolVar(&jsonOutput, "json", false, "print the report as JSON")
+	addUpdateFlag(outdatedCommand, &update)
+	addOfflineFlag(outdatedCommand, &offline)
+	RootCommand.AddCommand(outdatedCommand)
+}
+
+func doOutdated(projPath string, offline bool, jsonOutput bool) error {
+	printer.Trace("--- Outdated start ---")
+	defer printer.Trace("--- Outdated end ---")
+
+	if !offline {
+		var err error
+		if offline, err = offlineFromEnv(); err != nil {
+			return err
+		}
+	}
+
+	project, err := readUpdatedProject(projPath)
+	if err != nil {
+		return err
+	}
+
+	outdated, err := project.Outdated(context.Background(), offline)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(outdated, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		printer.Output("%s", data)
+		return nil
+	}
+	return writeOutdatedTable(printer.PrintWriter, outdated)
+}
+
+func writeOutdatedTable(w io.Writer, outdated []proj.OutdatedDependency) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tNAMESPACE\tCURRENT\tWANTED\tLATEST\tLOCATION")
+	for _, o := range outdated {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			o.Name, orDash(o.Namespace), o.Current, orDash(o.Wanted), orDash(o.Latest), o.Location)
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	var opts proj.PublishOptions
+
+	var publishCommand = &cobra.Command{
+		Use:   "publish",
+		Short: "Package and publish the project",
+		Long: `Package and publish the project
+
+Packages opa.project, and the project's source and test directories, into an archive named by the
+name and version declared in opa.project; e.g. build/my-lib-1.0.0.tar.gz.
+With --registry, the archive is added to a local directory-based registry; with --oci, it's pushed to
+an OCI repository, e.g. oci://registry.example.com/policies/my-lib, tagged with the project's version.
+A version that already exists in either is never overwritten, and fails the command.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			if err := doPublish(projPath, opts); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	publishCommand.Flags().StringVarP(&opts.OutputDir, "output", "o", defaultTargetDir, "directory to write the package archive to")
+	publishCommand.Flags().StringVar(&opts.Registry, "registry", "", "directory of a local registry to publish the package to")
+	publishCommand.Flags().StringVar(&opts.Oci, "oci", "", "oci:// location of a repository to push the package to")
+	RootCommand.AddCommand(publishCommand)
+}
+
+func doPublish(projPath string, opts proj.PublishOptions) error {
+	printer.Trace("--- Publish start ---")
+	defer printer.Trace("--- Publish end ---")
+
+	project, err := proj.ReadProjectFromFile(projPath, false)
+	if err != nil {
+		return err
+	}
+
+	pkg, err := project.Publish(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	printer.Output("Packaged %s %s into %s (sha256 %s)", pkg.Name, pkg.Version, pkg.Archive, pkg.Sha256)
+	return nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	var removeCommand = &cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a dependency from the project",
+		Long: `Remove a dependency from the project
+
+The dependency is removed from opa.project, together with its dependency directory,
+and the directories of any transitive dependencies no longer reachable from the project.
+If present, the opa.lock file is updated accordingly.
+A warning is printed for every import of the removed dependency's namespace still present in the project's source.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected exactly one dependency name")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			if err := doRemoveDependency(args[0], projPath); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	RootCommand.AddCommand(removeCommand)
+}
+
+func doRemoveDependency(name string, projectPath string) error {
+	printer.Trace("--- Remove start ---")
+	defer printer.Trace("--- Remove end ---")
+
+	project, err := proj.ReadProjectFromFile(projectPath, false)
+	if err != nil {
+		return err
+	}
+
+	dep, err := project.RemoveDependency(name)
+	if err != nil {
+		return err
+	}
+	printer.Info("Removing dependency '%s' @ '%s'", name, dep.Location)
+
+	if err := project.WriteToFile(projectPath, true); err != nil {
+		return err
+	}
+
+	if err := project.Prune(); err != nil {
+		return err
+	}
+
+	if dep.Namespace == "" {
+		return nil
+	}
+	imports, err := project.FindImports(dep.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, imp := range imports {
+		printer.Warn("%s refers to data.%s, of removed dependency %s", imp, dep.Namespace, name)
+	}
+
+	return nil
+}
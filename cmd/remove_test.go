@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRemoveDependency(t *testing.T) {
+	tests := []struct {
+		note             string
+		name             string
+		expectedProject  string
+		expectedDirs     []string
+		expectedLock     []string
+		expectedWarnings []string
+		expectedErr      string
+	}{
+		{
+			note: "dependency with transitive dependency",
+			name: "a",
+			expectedProject: `source:
+  - src
+dependencies:
+  b: file:/b
+`,
+			expectedDirs: []string{proj.DepId("b", "file:/b")},
+			expectedLock: []string{"name: b"},
+			expectedWarnings: []string{
+				"src/policy.rego:3 refers to data.a, of removed dependency a",
+				"src/other.rego:3 refers to data.a, of removed dependency a",
+				"src/other.rego:5 refers to data.a, of removed dependency a",
+			},
+		},
+		{
+			note: "dependency without transitive dependencies",
+			name: "b",
+			expectedProject: `source:
+  - src
+dependencies:
+  a: file:/a
+`,
+			expectedDirs: []string{proj.DepId("a", "file:/a"), proj.DepId("a.c", "file:/c")},
+			expectedLock: []string{"name: a", "name: c"},
+		},
+		{
+			note:        "unknown dependency",
+			name:        "x",
+			expectedErr: "dependency x not found in project",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"opa.project":     "source:\n  - src\ndependencies:\n  a: file:/a\n  b: file:/b\n",
+				"src/policy.rego": "package main\n\nimport data.a.lib\n\nallow := lib.allow\n",
+				"src/other.rego":  "package other\n\nallow := data.a.lib.allow\n\ndeny := data[\"a\"].lib.deny\n\nx := data.ab.allow\n",
+				"a/opa.project":   "dependencies:\n  c: file:/c\n",
+				"a/lib.rego":      "package lib\n\nallow := true\n",
+				"b/lib.rego":      "package lib\n\nallow := true\n",
+				"c/lib.rego":      "package lib\n\nallow := true\n",
+			}
+			for name, content := range files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := doUpdate(dir, proj.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			var log bytes.Buffer
+			printer.LogWriter = &log
+			defer func() {
+				printer.LogWriter = os.Stderr
+			}()
+
+			err := doRemoveDependency(tc.name, dir)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, actual := readFile(t, filepath.Join(dir, "opa.project")); *actual != tc.expectedProject {
+				t.Fatalf("expected project file:\n%s\ngot:\n%s", tc.expectedProject, *actual)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(dir, ".opa", "dependencies"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dirs []string
+			for _, entry := range entries {
+				if entry.IsDir() {
+					dirs = append(dirs, entry.Name())
+				}
+			}
+			if strings.Join(dirs, ",") != strings.Join(sorted(tc.expectedDirs), ",") {
+				t.Fatalf("expected dependency directories %v, got %v", sorted(tc.expectedDirs), dirs)
+			}
+
+			_, lock := readFile(t, filepath.Join(dir, "opa.lock"))
+			if count := strings.Count(*lock, "- name:"); count != len(tc.expectedLock) {
+				t.Fatalf("expected %d locked dependencies, got:\n%s", len(tc.expectedLock), *lock)
+			}
+			for _, expected := range tc.expectedLock {
+				if !strings.Contains(*lock, expected) {
+					t.Fatalf("expected lock file to contain '%s', got:\n%s", expected, *lock)
+				}
+			}
+
+			if count := strings.Count(log.String(), "Warning"); count != len(tc.expectedWarnings) {
+				t.Fatalf("expected %d warnings, got:\n%s", len(tc.expectedWarnings), log.String())
+			}
+			for _, expected := range tc.expectedWarnings {
+				if !strings.Contains(log.String(), expected) {
+					t.Fatalf("expected warning containing '%s', got:\n%s", expected, log.String())
+				}
+			}
+		})
+	}
+}
+
+func sorted(s []string) []string {
+	cpy := append([]string(nil), s...)
+	sort.Strings(cpy)
+	return cpy
+}
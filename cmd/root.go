@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/johanfylling/odm/utils"
 	"github.com/spf13/cobra"
 	"os"
 	"path"
@@ -20,3 +23,49 @@ func init() {
 func addNoUpdateFlag(cmd *cobra.Command, v *bool) {
 	cmd.Flags().BoolVar(v, "no-update", false, "do not sync dependencies before executing this command")
 }
+
+func addUpdateFlag(cmd *cobra.Command, v *bool) {
+	cmd.Flags().BoolVar(v, "update", false, "sync dependencies before executing this command, instead of reporting on the dependencies as last updated")
+}
+
+func addLockedFlag(cmd *cobra.Command, v *bool) {
+	cmd.Flags().BoolVar(v, "locked", false, "fail if dependencies don't match the lock file, instead of updating it")
+}
+
+func addOfflineFlag(cmd *cobra.Command, v *bool) {
+	cmd.Flags().BoolVar(v, "offline", false, "resolve dependencies from the dependency cache only, without network access. Also enabled by the ODM_OFFLINE environment variable")
+}
+
+func addAllowOverlapFlag(cmd *cobra.Command, v *bool) {
+	cmd.Flags().BoolVar(v, "allow-overlap", false, "warn, rather than fail, when the project and its dependencies contribute to the same paths in data")
+}
+
+func addJobsFlag(cmd *cobra.Command, v *int) {
+	cmd.Flags().IntVar(v, "jobs", 0, "maximum number of dependencies to fetch concurrently. Defaults to the number of CPUs")
+}
+
+// readUpdatedProject reads and loads the project at projPath, with its dependencies as last updated; failing if the
+// project declares dependencies but has never been updated.
+func readUpdatedProject(projPath string) (*proj.Project, error) {
+	project, err := proj.ReadAndLoadProject(projPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(project.Dependencies) > 0 && !utils.FileExists(project.LockFilePath()) {
+		return nil, fmt.Errorf("lock file %s not found; run 'odm update' first, or pass --update", project.LockFilePath())
+	}
+	return project, nil
+}
+
+// doUpdateOrCheckOverlaps updates the project's dependencies, which checks them for paths in data they and the project
+// both contribute to; or, with noUpdate, only checks the dependencies as last updated.
+func doUpdateOrCheckOverlaps(projPath string, noUpdate bool, opts proj.UpdateOptions) error {
+	if !noUpdate {
+		return doUpdate(projPath, opts)
+	}
+	project, err := proj.ReadAndLoadProject(projPath, true)
+	if err != nil {
+		return err
+	}
+	return project.CheckOverlaps(opts.AllowOverlap)
+}
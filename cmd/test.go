@@ -11,6 +11,10 @@ import (
 
 func init() {
 	var noUpdate bool
+	var locked bool
+	var offline bool
+	var jobs int
+	var allowOverlap bool
 	var includeDeps bool
 
 	var testCommand = &cobra.Command{
@@ -20,11 +24,10 @@ func init() {
 		Run: func(cmd *cobra.Command, args []string) {
 			projPath := "."
 
-			if !noUpdate {
-				if err := doUpdate(projPath); err != nil {
-					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-					os.Exit(1)
-				}
+			opts := proj.UpdateOptions{Frozen: locked, Offline: offline, Jobs: jobs, AllowOverlap: allowOverlap}
+			if err := doUpdateOrCheckOverlaps(projPath, noUpdate, opts); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
 			}
 
 			if err := doTest(projPath, includeDeps, args); err != nil {
@@ -36,6 +39,10 @@ func init() {
 
 	testCommand.Flags().BoolVar(&includeDeps, "include-deps", false, "Include dependency tests")
 	addNoUpdateFlag(testCommand, &noUpdate)
+	addLockedFlag(testCommand, &locked)
+	addOfflineFlag(testCommand, &offline)
+	addJobsFlag(testCommand, &jobs)
+	addAllowOverlapFlag(testCommand, &allowOverlap)
 	RootCommand.AddCommand(testCommand)
 }
 
@@ -48,6 +55,10 @@ func doTest(projPath string, includeDependencies bool, args []string) error {
 		return err
 	}
 
+	if err := project.CompileSources(); err != nil {
+		return err
+	}
+
 	dataLocations, err := project.DataLocations()
 	if err != nil {
 		return fmt.Errorf("error getting data locations: %s", err)
@@ -62,9 +73,9 @@ func doTest(projPath string, includeDependencies bool, args []string) error {
 
 	opa := utils.NewOpa(dataLocations...)
 	if output, err := opa.Test(args...); err != nil {
-		return fmt.Errorf("error running opa test:\n %s", err)
+		return fmt.Errorf("error running opa test:\n %s", project.SourcePaths(err.Error()))
 	} else {
-		printer.Output(output)
+		printer.Output(project.SourcePaths(output))
 	}
 
 	return nil
@@ -3,6 +3,8 @@ package cmd
 import (
 	"bytes"
 	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -65,6 +67,18 @@ data.bar.no_deps.test.test_allow: PASS (%TIME%)
 --------------------------------------------------------------------------------
 PASS: 3/3`,
 		},
+		{
+			name:       "Project with imported dependency, and tests within its source",
+			projectDir: filepath.Join(rootDir, "testdata", "projects", "imported-nested-tests"),
+			expectedOutput: `%ROOT_DIR%/testdata/projects/imported-nested-tests/.opa/dependencies/51e501cd6ccf5217fddd48004b56560a9ee24cecbd64a644104dd112a2b27b68/test/test.rego:
+data.sl.test.test_allow: PASS (%TIME%)
+
+%ROOT_DIR%/testdata/projects/imported-nested-tests/src/tests/tests.rego:
+data.main.test_allow: PASS (%TIME%)
+--------------------------------------------------------------------------------
+PASS: 2/2
+`,
+		},
 	}
 
 	r := regexp.MustCompile(`(FAIL|PASS) \(.*s\)`)
@@ -77,7 +91,7 @@ PASS: 3/3`,
 			output := bytes.Buffer{}
 			printer.PrintWriter = &output
 			args := []string{"-v"}
-			if err := doUpdate(tc.projectDir); err != nil {
+			if err := doUpdate(tc.projectDir, proj.UpdateOptions{}); err != nil {
 				t.Fatal(err)
 			}
 			if err := doTest(tc.projectDir, true, args); err != nil {
@@ -91,3 +105,27 @@ PASS: 3/3`,
 		})
 	}
 }
+
+func TestTestProjectErrorsPointAtSources(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"opa.project":     "source: src\nimports:\n  lists: sl\n",
+		"src/policy.rego": "package main\n\nimport data.lists\n\nallow {\n    lists.allow\n    undefined_fn(1)\n}\n",
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The module is compiled against the imports, but reported at its own path and row
+	expected := filepath.Join(dir, "src", "policy.rego") + ":7"
+	err := doTest(dir, false, nil)
+	if err == nil || !strings.Contains(err.Error(), expected) || strings.Contains(err.Error(), ".opa") {
+		t.Fatalf("expected error pointing at %s, got %v", expected, err)
+	}
+}
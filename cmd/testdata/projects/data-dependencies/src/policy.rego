@@ -0,0 +1,6 @@
+package main
+
+allow {
+    data.sl.test.allow
+    data.sl.foo.bar == "baz"
+}
@@ -0,0 +1,8 @@
+package main
+
+import data.lists
+
+allow {
+    lists.allow
+    data.lists.allow
+}
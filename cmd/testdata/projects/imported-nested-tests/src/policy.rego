@@ -0,0 +1,9 @@
+package main
+
+import data.lists
+
+default allow := false
+
+allow {
+    lists.allow
+}
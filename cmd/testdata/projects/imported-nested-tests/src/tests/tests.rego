@@ -0,0 +1,6 @@
+package main
+
+test_allow {
+    allow
+    data.lists.allow
+}
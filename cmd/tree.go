@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	treeFormatText = "text"
+	treeFormatJSON = "json"
+	treeFormatDot  = "dot"
+)
+
+func init() {
+	var update bool
+	var offline bool
+	var depth int
+	var jsonOutput bool
+	var dotOutput bool
+
+	var treeCommand = &cobra.Command{
+		Use:   "tree",
+		Short: "Print the dependency tree of the project",
+		Long: `Print the dependency tree of the project
+
+Every dependency is listed with its location, the revision it's locked at, and the full namespace it's mounted at.
+Dependencies are sorted by name, and reported as last updated, unless --update is given.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if depth < 0 {
+				return fmt.Errorf("invalid depth %d; must be at least 0", depth)
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			if update {
+				if err := doUpdate(projPath, proj.UpdateOptions{Offline: offline}); err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			format := treeFormatText
+			if jsonOutput {
+				format = treeFormatJSON
+			} else if dotOutput {
+				format = treeFormatDot
+			}
+
+			if err := doTree(projPath, depth, format); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	treeCommand.Flags().IntVar(&depth, "depth", 0, "maximum depth of dependencies to print. 0 prints all dependencies")
+	treeCommand.Flags().BoolVar(&jsonOutput, "json", false, "print the tree as JSON. Mutually exclusive with --dot")
+	treeCommand.Flags().BoolVar(&dotOutput, "dot", false, "print the tree as a Graphviz graph. Mutually exclusive with --json")
+	treeCommand.MarkFlagsMutuallyExclusive("json", "dot")
+	addUpdateFlag(treeCommand, &update)
+	addOfflineFlag(treeCommand, &offline)
+	RootCommand.AddCommand(treeCommand)
+}
+
+func doTree(projPath string, depth int, format string) error {
+	printer.Trace("--- Tree start ---")
+	defer printer.Trace("--- Tree end ---")
+
+	project, err := readUpdatedProject(projPath)
+	if err != nil {
+		return err
+	}
+
+	tree, err := project.Tree(depth)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case treeFormatJSON:
+		return tree.WriteJSON(printer.PrintWriter)
+	case treeFormatDot:
+		return tree.WriteDot(printer.PrintWriter)
+	default:
+		return tree.WriteText(printer.PrintWriter)
+	}
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportCommandsReadLastUpdate(t *testing.T) {
+	reports := map[string]func(dir string) error{
+		"tree": func(dir string) error {
+			return doTree(dir, 0, treeFormatText)
+		},
+		"why": func(dir string) error {
+			return doWhy(dir, "a")
+		},
+		"outdated": func(dir string) error {
+			return doOutdated(dir, true, false)
+		},
+	}
+
+	for name, report := range reports {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{
+				"opa.project": "dependencies:\n  a: file:/a\n",
+				"a/lib.rego":  "package lib\n",
+			}
+			for file, content := range files {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expectedErr := "lock file " + filepath.Join(dir, "opa.lock") + " not found"
+			if err := report(dir); err == nil || !strings.Contains(err.Error(), expectedErr) {
+				t.Fatalf("expected error containing '%s', got %v", expectedErr, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".opa")); !os.IsNotExist(err) {
+				t.Fatalf("expected no dependencies to be materialized, got %v", err)
+			}
+
+			if err := doUpdate(dir, proj.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			lock := mustReadFile(dir, "opa.lock")
+
+			// Changes to a local dependency aren't picked up without an update
+			if err := os.WriteFile(filepath.Join(dir, "a", "lib.rego"), []byte("package changed\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			printer.PrintWriter = &bytes.Buffer{}
+			defer func() {
+				printer.PrintWriter = os.Stdout
+			}()
+
+			if err := report(dir); err != nil {
+				t.Fatal(err)
+			}
+			if actual := mustReadFile(dir, "opa.lock"); *actual != *lock {
+				t.Fatalf("expected lock file to be unchanged:\n%s\ngot:\n%s", *lock, *actual)
+			}
+		})
+	}
+}
@@ -8,29 +8,59 @@ import (
 	"github.com/spf13/cobra"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
+const offlineEnv = "ODM_OFFLINE"
+
 func init() {
+	var opts proj.UpdateOptions
+
 	var updateCommand = &cobra.Command{
 		Use:   "update",
 		Short: "Update OPA project dependencies",
+		Long: `Update OPA project dependencies
+
+The resolved revision of every dependency is recorded in the opa.lock file next to opa.project.
+Subsequent updates reproduce the locked revisions, unless --refresh is set.
+With --frozen, the update fails instead of changing the lock file, e.g. when a
+dependency is missing from the lock file, or its content doesn't match the locked digest.
+With --offline, or if the ODM_OFFLINE environment variable is set to true, no remote
+repository is contacted; dependencies are resolved from the dependency cache, or
+from dependency directories already materialized by a previous update.
+Dependencies are fetched concurrently; --jobs limits how many at a time.
+The update fails if the project and its dependencies declare the same package, or data documents at
+the same paths in data; with --allow-overlap, the overlaps are printed as warnings instead.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			projPath := "."
 
-			if err := doUpdate(projPath); err != nil {
+			if err := doUpdate(projPath, opts); err != nil {
 				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 				os.Exit(1)
 			}
 		},
 	}
 
+	updateCommand.Flags().BoolVar(&opts.Refresh, "refresh", false, "re-resolve all dependencies, ignoring revisions recorded in the lock file. Mutually exclusive with --frozen")
+	updateCommand.Flags().BoolVar(&opts.Frozen, "frozen", false, "fail if dependencies don't match the lock file, instead of updating it. Mutually exclusive with --refresh")
+	updateCommand.MarkFlagsMutuallyExclusive("refresh", "frozen")
+	addOfflineFlag(updateCommand, &opts.Offline)
+	addJobsFlag(updateCommand, &opts.Jobs)
+	addAllowOverlapFlag(updateCommand, &opts.AllowOverlap)
 	RootCommand.AddCommand(updateCommand)
 }
 
-func doUpdate(projectPath string) error {
+func doUpdate(projectPath string, opts proj.UpdateOptions) error {
 	printer.Trace("--- Project update start ---")
 	defer printer.Trace("--- Project update end ---")
 
+	if !opts.Offline {
+		var err error
+		if opts.Offline, err = offlineFromEnv(); err != nil {
+			return err
+		}
+	}
+
 	project, err := proj.ReadProjectFromFile(projectPath, false)
 	if err != nil {
 		return err
@@ -47,17 +77,27 @@ func doUpdate(projectPath string) error {
 		}
 	}
 
-	if err := os.RemoveAll(depRootDir); err != nil {
+	// Dependency directories are kept between updates; only those that have changed are materialized again
+	if err := os.MkdirAll(depRootDir, 0755); err != nil {
 		return err
 	}
 
-	if err := os.Mkdir(depRootDir, 0755); err != nil {
-		return err
-	}
-
-	if err := project.Update(); err != nil {
+	if err := project.Update(opts); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// offlineFromEnv reports whether offline mode is enabled through the ODM_OFFLINE environment variable.
+func offlineFromEnv() (bool, error) {
+	v, ok := os.LookupEnv(offlineEnv)
+	if !ok || v == "" {
+		return false, nil
+	}
+	offline, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value '%s' for %s environment variable; expected true or false", v, offlineEnv)
+	}
+	return offline, nil
+}
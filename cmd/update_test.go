@@ -121,7 +121,7 @@ test_allow {
 		defer cleanup(tc.projectDir)
 
 		t.Run(tc.name, func(t *testing.T) {
-			if err := doUpdate(tc.projectDir); err != nil {
+			if err := doUpdate(tc.projectDir, proj.UpdateOptions{}); err != nil {
 				t.Fatal(err)
 			}
 
@@ -152,6 +152,39 @@ test_allow {
 	}
 }
 
+func TestOfflineFromEnv(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    bool
+		expectedErr string
+	}{
+		{value: "", expected: false},
+		{value: "true", expected: true},
+		{value: "1", expected: true},
+		{value: "false", expected: false},
+		{value: "yes", expectedErr: "invalid value 'yes' for ODM_OFFLINE environment variable"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(offlineEnv, tc.value)
+			offline, err := offlineFromEnv()
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offline != tc.expected {
+				t.Fatalf("expected offline %v, got %v", tc.expected, offline)
+			}
+		})
+	}
+}
+
 func ptr(s string) *string {
 	return &s
 }
@@ -189,6 +222,7 @@ func mustReadFile(path ...string) *string {
 func cleanup(projectDir string, files ...string) {
 	dotOpaDir := filepath.Join(projectDir, ".opa")
 	_ = os.RemoveAll(dotOpaDir)
+	_ = os.Remove(filepath.Join(projectDir, "opa.lock"))
 
 	for _, file := range files {
 		_ = os.RemoveAll(filepath.Join(projectDir, file))
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/johanfylling/odm/utils"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	var opts proj.UpgradeOptions
+	var runTests bool
+	var jobs int
+
+	var upgradeCommand = &cobra.Command{
+		Use:   "upgrade [name...]",
+		Short: "Upgrade git dependencies pinned at a tag",
+		Long: `Upgrade git dependencies pinned at a tag
+
+Rewrites the tags of the named dependencies, or of all git dependencies pinned at a tag, in opa.project
+to their latest tag; to their latest tag with the same major version, with --compatible; or to the tag
+given by --to. Only tags that are semantic versions are considered.
+Dependencies are updated, and, with --test, the project's tests are run, before the upgrade is kept.
+If either fails, opa.project and opa.lock are left unchanged.
+The rest of opa.project, including comments and formatting, is preserved.
+Dependencies declaring a version constraint aren't upgraded; they stay at their locked tag until
+'odm update --refresh' moves them to the newest matching tag.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			opts.Names = args
+			if err := doUpgrade(projPath, opts, runTests, jobs); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	upgradeCommand.Flags().BoolVar(&opts.Compatible, "compatible", false, "upgrade to the latest tag with the same major version. Mutually exclusive with --to")
+	upgradeCommand.Flags().StringVar(&opts.To, "to", "", "tag to upgrade a single named dependency to. Mutually exclusive with --compatible")
+	upgradeCommand.MarkFlagsMutuallyExclusive("compatible", "to")
+	upgradeCommand.Flags().BoolVar(&runTests, "test", false, "run the project's tests before keeping the upgrade")
+	addOfflineFlag(upgradeCommand, &opts.Offline)
+	addJobsFlag(upgradeCommand, &jobs)
+	RootCommand.AddCommand(upgradeCommand)
+}
+
+func doUpgrade(projPath string, opts proj.UpgradeOptions, runTests bool, jobs int) error {
+	printer.Trace("--- Upgrade start ---")
+	defer printer.Trace("--- Upgrade end ---")
+
+	if !opts.Offline {
+		var err error
+		if opts.Offline, err = offlineFromEnv(); err != nil {
+			return err
+		}
+	}
+
+	project, err := proj.ReadProjectFromFile(projPath, false)
+	if err != nil {
+		return err
+	}
+
+	upgrades, err := project.PlanUpgrades(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if len(upgrades) == 0 {
+		printer.Output("All dependencies are up to date")
+		return nil
+	}
+
+	projectFile := project.FilePath()
+	original, err := os.ReadFile(projectFile)
+	if err != nil {
+		return fmt.Errorf("failed to read project file %s: %w", projectFile, err)
+	}
+	upgraded, err := proj.UpgradeProjectFile(original, upgrades)
+	if err != nil {
+		return err
+	}
+
+	lockFile := project.LockFilePath()
+	var originalLock []byte
+	if utils.FileExists(lockFile) {
+		if originalLock, err = os.ReadFile(lockFile); err != nil {
+			return fmt.Errorf("failed to read lock file %s: %w", lockFile, err)
+		}
+	}
+
+	if err := os.WriteFile(projectFile, upgraded, 0644); err != nil {
+		return fmt.Errorf("failed to write project file %s: %w", projectFile, err)
+	}
+
+	err = doUpdate(projPath, proj.UpdateOptions{Offline: opts.Offline, Jobs: jobs})
+	if err == nil && runTests {
+		err = doTest(projPath, false, nil)
+	}
+	if err != nil {
+		printer.Info("Upgrade failed, restoring %s", projectFile)
+		if err := restoreUpgrade(projPath, projectFile, original, lockFile, originalLock, opts.Offline, jobs); err != nil {
+			return fmt.Errorf("failed to restore project after failed upgrade: %w", err)
+		}
+		return fmt.Errorf("upgrade failed, %s left unchanged: %w", projectFile, err)
+	}
+
+	for _, u := range upgrades {
+		printer.Output("Upgraded %s from %s to %s", u.Name, u.From, u.To)
+	}
+	return nil
+}
+
+// restoreUpgrade restores the project and lock files to their content before a failed upgrade, and updates the
+// dependencies to match them again.
+func restoreUpgrade(projPath, projectFile string, project []byte, lockFile string, lock []byte, offline bool, jobs int) error {
+	if err := os.WriteFile(projectFile, project, 0644); err != nil {
+		return err
+	}
+	if lock == nil {
+		if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if err := os.WriteFile(lockFile, lock, 0644); err != nil {
+		return err
+	}
+	return doUpdate(projPath, proj.UpdateOptions{Offline: offline, Jobs: jobs})
+}
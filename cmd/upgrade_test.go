@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/johanfylling/odm/proj"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpgrade(t *testing.T) {
+	repoDir := t.TempDir()
+	repo, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tag := range []string{"v1.0.0", "v1.1.0"} {
+		if err := os.WriteFile(filepath.Join(repoDir, "policy.rego"), []byte(fmt.Sprintf("package lib\n\nx := %d\n", i+1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wt, err := repo.Worktree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add("policy.rego"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := wt.Commit(tag, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.CreateTag(tag, hash, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	project := fmt.Sprintf(`# Pinned for the tests below
+source: src
+dependencies:
+  lib: git+file://%s#v1.0.0 # upgraded by odm upgrade
+`, repoDir)
+
+	tests := []struct {
+		note            string
+		test            string
+		runTests        bool
+		expectedProject string
+		expectedErr     string
+	}{
+		{
+			note:            "upgraded",
+			test:            "package main\n\ntest_x {\n\tdata.lib.lib.x == 2\n}\n",
+			runTests:        true,
+			expectedProject: strings.Replace(project, "#v1.0.0", "#v1.1.0", 1),
+		},
+		{
+			note:            "failing tests not run",
+			test:            "package main\n\ntest_x {\n\tdata.lib.lib.x == 1\n}\n",
+			expectedProject: strings.Replace(project, "#v1.0.0", "#v1.1.0", 1),
+		},
+		{
+			note:            "failing tests",
+			test:            "package main\n\ntest_x {\n\tdata.lib.lib.x == 1\n}\n",
+			runTests:        true,
+			expectedProject: project,
+			expectedErr:     "upgrade failed",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "opa.project"), []byte(project), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "src", "policy_test.rego"), []byte(tc.test), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := doUpdate(dir, proj.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			_, lock := readFile(t, filepath.Join(dir, "opa.lock"))
+
+			err := doUpgrade(dir, proj.UpgradeOptions{}, tc.runTests, 0)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				if _, actual := readFile(t, filepath.Join(dir, "opa.lock")); *actual != *lock {
+					t.Fatalf("expected lock file to be restored:\n%s\ngot:\n%s", *lock, *actual)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, actual := readFile(t, filepath.Join(dir, "opa.project")); *actual != tc.expectedProject {
+				t.Fatalf("expected project file:\n%s\ngot:\n%s", tc.expectedProject, *actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/proj"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	var update bool
+	var offline bool
+
+	var whyCommand = &cobra.Command{
+		Use:   "why <name|namespace>",
+		Short: "Explain why a dependency is part of the project",
+		Long: `Explain why a dependency is part of the project
+
+Prints every path from the project to the dependencies matching the given dependency name,
+or namespace; e.g. 'data.lib.util'. A namespace within a dependency's namespace, such as
+the name of a package it contains, matches the dependency with the most specific namespace.
+For every dependency along a path, its location, declared namespace, and full namespace are printed.
+Dependencies are reported as last updated, unless --update is given.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("expected exactly one dependency name or namespace")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			projPath := "."
+
+			if update {
+				if err := doUpdate(projPath, proj.UpdateOptions{Offline: offline}); err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := doWhy(projPath, args[0]); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addUpdateFlag(whyCommand, &update)
+	addOfflineFlag(whyCommand, &offline)
+	RootCommand.AddCommand(whyCommand)
+}
+
+func doWhy(projPath string, query string) error {
+	printer.Trace("--- Why start ---")
+	defer printer.Trace("--- Why end ---")
+
+	project, err := readUpdatedProject(projPath)
+	if err != nil {
+		return err
+	}
+
+	paths := project.FindPaths(query)
+	if len(paths) == 0 {
+		return fmt.Errorf("no dependency matching '%s' found", query)
+	}
+
+	root := project.Name
+	if root == "" {
+		root = "root"
+	}
+	for i, path := range paths {
+		if i > 0 {
+			printer.Output("")
+		}
+		if err := path.WriteText(printer.PrintWriter, root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package printer
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
 const (
@@ -28,10 +31,13 @@ func Output(format string, args ...any) {
 	out(PrintWriter, format, args...)
 }
 
+// Warn prints a warning, regardless of log level.
+func Warn(format string, args ...any) {
+	Logger{}.Warn(format, args...)
+}
+
 func Info(format string, args ...any) {
-	if LogLevel >= InfoLevel {
-		out(LogWriter, format, args...)
-	}
+	Logger{}.Info(format, args...)
 }
 
 func InfoPrinter() io.Writer {
@@ -43,17 +49,11 @@ func InfoPrinter() io.Writer {
 }
 
 func Debug(format string, args ...any) {
-	if LogLevel >= DebugLevel {
-		out(LogWriter, format, args...)
-	}
+	Logger{}.Debug(format, args...)
 }
 
 func DebugPrinter() io.Writer {
-	if LogLevel >= DebugLevel {
-		return LogWriter
-	} else {
-		return noOpWriter
-	}
+	return Logger{}.DebugPrinter()
 }
 
 func Trace(format string, args ...any) {
@@ -69,3 +69,78 @@ func TracePrinter() io.Writer {
 		return noOpWriter
 	}
 }
+
+type bufferKey struct{}
+
+// Logger prints messages at the package log level, to LogWriter; or into a buffer, if obtained from a context holding
+// one. The package-level functions print through the zero Logger.
+type Logger struct {
+	buf *Buffer
+}
+
+// From returns the logger of ctx; which holds messages in the buffer of the closest context returned by WithBuffer,
+// if any.
+func From(ctx context.Context) Logger {
+	buf, _ := ctx.Value(bufferKey{}).(*Buffer)
+	return Logger{buf: buf}
+}
+
+func (l Logger) writer() io.Writer {
+	if l.buf != nil {
+		return l.buf
+	}
+	return LogWriter
+}
+
+// Warn prints a warning, regardless of log level.
+func (l Logger) Warn(format string, args ...any) {
+	out(l.writer(), "Warning: "+format, args...)
+}
+
+func (l Logger) Info(format string, args ...any) {
+	if LogLevel >= InfoLevel {
+		out(l.writer(), format, args...)
+	}
+}
+
+func (l Logger) Debug(format string, args ...any) {
+	if LogLevel >= DebugLevel {
+		out(l.writer(), format, args...)
+	}
+}
+
+func (l Logger) DebugPrinter() io.Writer {
+	if LogLevel >= DebugLevel {
+		return l.writer()
+	} else {
+		return noOpWriter
+	}
+}
+
+// Buffer holds the messages printed by the logger of a context returned by WithBuffer, until flushed.
+type Buffer struct {
+	mu     sync.Mutex
+	parent Logger
+	data   bytes.Buffer
+}
+
+// WithBuffer returns a context whose logger holds messages in the returned buffer; for concurrent work to print its
+// messages in a deterministic order, regardless of which work finishes first.
+func WithBuffer(ctx context.Context) (context.Context, *Buffer) {
+	buf := &Buffer{parent: From(ctx)}
+	return context.WithValue(ctx, bufferKey{}, buf), buf
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data.Write(p)
+}
+
+// Flush prints the held messages to the logger of the context the buffer was created from, and empties the buffer.
+func (b *Buffer) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, _ = b.parent.writer().Write(b.data.Bytes())
+	b.data.Reset()
+}
@@ -0,0 +1,393 @@
+package proj
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cacheArchiveDir = "archives"
+	cacheBundleDir  = "bundles"
+	// maxArchiveSize is the maximum total size of the files extracted from an archive
+	maxArchiveSize = 1 << 30
+)
+
+// isArchiveLocation reports whether location is the url of an archive downloaded over HTTP(S), or the location of a
+// bundle.
+func isArchiveLocation(location string) bool {
+	return isBundleLocation(location) || isHttpLocation(location)
+}
+
+func isHttpLocation(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// archiveFormat returns the format of the archive at location, by the extension of its url path; .tar.gz, .tgz, or
+// .zip.
+func archiveFormat(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive url %s: %w", location, err)
+	}
+	name := strings.ToLower(path.Base(u.Path))
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return ext, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported archive format: %s; expected .tar.gz, .tgz, or .zip", location)
+}
+
+// archiveChecksum returns the declared sha256 checksum of an archive dependency, in lower case; if any.
+func (d Dependency) archiveChecksum() (string, error) {
+	checksum := strings.ToLower(strings.TrimPrefix(d.Sha256, "sha256:"))
+	if checksum == "" {
+		return "", nil
+	}
+	if len(checksum) != sha256.Size*2 || strings.Trim(checksum, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid sha256 checksum of dependency %s: %s", d.Name, d.Sha256)
+	}
+	return checksum, nil
+}
+
+// cachedArchiveDir returns the cache directory holding the extracted content of the archive, or bundle, with the given
+// sha256 checksum. As checksums address their content, the directory is shared by all locations serving the archive.
+func cachedArchiveDir(checksum string, bundle bool) (string, error) {
+	dir, err := archiveCacheDir(bundle)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, checksum), nil
+}
+
+// archiveCacheDir returns the cache directory holding extracted archives, or bundles.
+func archiveCacheDir(bundle bool) (string, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	if bundle {
+		return filepath.Join(root, cacheBundleDir), nil
+	}
+	return filepath.Join(root, cacheArchiveDir), nil
+}
+
+// fetchArchive downloads the archive of the dependency, or reads it from a local file, verifies it against the
+// declared checksum, and the expected one, if any, and extracts it into the cache; returning the directory of its
+// content, and its sha256 checksum. If an archive, other than a bundle, contains a single directory, that directory is the root of its content.
+// An archive already extracted to the cache under the expected checksum, if known, isn't fetched again; if offline,
+// it must have been, unless it's a local file.
+func (d Dependency) fetchArchive(ctx context.Context, rootDir, expected string, offline bool) (dir string, sum string, err error) {
+	bundle := isBundleLocation(d.Location)
+	source := strings.TrimPrefix(d.Location, bundlePrefix)
+	local := !isHttpLocation(source)
+
+	checksum, err := d.archiveChecksum()
+	if err != nil {
+		return "", "", err
+	}
+	if expected != "" {
+		if dir, err := cachedArchiveDir(expected, bundle); err != nil {
+			return "", "", err
+		} else if utils.FileExists(dir) {
+			printer.From(ctx).Debug("Using cached archive %s for %s", expected, d.Location)
+			return dir, expected, nil
+		}
+	}
+	if offline && !local {
+		return "", "", fmt.Errorf("archive %s %w", d.Location, errNotCached)
+	}
+
+	// Bundles are gzipped tarballs, whatever they're named
+	format := ".tar.gz"
+	if !bundle {
+		if format, err = archiveFormat(source); err != nil {
+			return "", "", err
+		}
+	}
+
+	archivesDir, err := archiveCacheDir(bundle)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(archivesDir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(archivesDir, ".tmp-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	archivePath := filepath.Join(tmpDir, "archive"+format)
+	if local {
+		sum, err = copyLocalFile(rootDir, source, archivePath)
+	} else {
+		sum, err = download(ctx, source, archivePath)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if checksum != "" && sum != checksum {
+		return "", "", fmt.Errorf("checksum of archive %s does not match; expected sha256 %s, got %s",
+			d.Location, checksum, sum)
+	}
+	// The locked checksum pins the archive as a declared one does; an archive changed since must be refreshed
+	if expected != "" && sum != expected {
+		return "", "", fmt.Errorf("archive %s has changed since it was locked; expected sha256 %s, got %s. "+
+			"Run update with --refresh to accept the change", d.Location, expected, sum)
+	}
+
+	contentDir := filepath.Join(tmpDir, "content")
+	w := newArchiveWriter(contentDir)
+	if format == ".zip" {
+		err = extractZip(ctx, archivePath, w)
+	} else {
+		err = extractTarGz(ctx, archivePath, w)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to extract archive %s: %w", d.Location, err)
+	}
+
+	if entries, err := os.ReadDir(contentDir); err == nil && len(entries) == 1 && entries[0].IsDir() && !bundle {
+		contentDir = filepath.Join(contentDir, entries[0].Name())
+	}
+
+	if dir, err = cachedArchiveDir(sum, bundle); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(contentDir, dir); err != nil && !utils.FileExists(dir) {
+		return "", "", fmt.Errorf("failed to write archive %s to cache: %w", d.Location, err)
+	}
+	return dir, sum, nil
+}
+
+// download writes the content at location to file, and returns its sha256 checksum.
+func download(ctx context.Context, location, file string) (string, error) {
+	printer.From(ctx).Debug("Downloading %s", location)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive url %s: %w", location, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", location, resp.Status)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// copyLocalFile copies the file at the file: location, relative to rootDir, to file, and returns its sha256 checksum.
+func copyLocalFile(rootDir, location, file string) (string, error) {
+	path, err := utils.NormalizeFilePath(location)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", location, err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+	dst, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = dst.Close()
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", location, err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// archiveEntryPath returns the path within dir of the archive entry name; or an error if the entry would be
+// extracted outside dir.
+func archiveEntryPath(dir, name string) (string, error) {
+	name = filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal absolute path %s in archive", name)
+	}
+	target := filepath.Join(dir, name)
+	if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path %s in archive", name)
+	}
+	return target, nil
+}
+
+// archiveWriter writes the files of an archive to a directory, bounding their total size. An artifact of several
+// archives, such as the layers of an OCI artifact, is written by a single writer, bounding the size of all of them.
+type archiveWriter struct {
+	dir     string
+	written int64
+}
+
+func newArchiveWriter(dir string) *archiveWriter {
+	return &archiveWriter{dir: filepath.Clean(dir)}
+}
+
+func (w *archiveWriter) mkdir(name string) error {
+	target, err := archiveEntryPath(w.dir, name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func (w *archiveWriter) writeFile(name string, r io.Reader) error {
+	target, err := archiveEntryPath(w.dir, name)
+	if err != nil {
+		return err
+	}
+	if target == w.dir {
+		return fmt.Errorf("illegal path %s in archive", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	n, err := io.Copy(f, io.LimitReader(r, maxArchiveSize-w.written+1))
+	w.written += n
+	if err != nil {
+		return err
+	}
+	if w.written > maxArchiveSize {
+		return fmt.Errorf("archive content exceeds %d bytes", int64(maxArchiveSize))
+	}
+	return f.Close()
+}
+
+func extractTarGz(ctx context.Context, archive string, w *archiveWriter) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = w.mkdir(header.Name)
+		case tar.TypeReg:
+			err = w.writeFile(header.Name, tr)
+		case tar.TypeXGlobalHeader:
+		default:
+			// Links may point outside the dependency, and aren't extracted
+			printer.From(ctx).Warn("Skipping %s in archive, of unsupported type", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(ctx context.Context, archive string, w *archiveWriter) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			err = w.mkdir(file.Name)
+		case mode.IsRegular():
+			err = func() error {
+				r, err := file.Open()
+				if err != nil {
+					return err
+				}
+				defer func() {
+					_ = r.Close()
+				}()
+				return w.writeFile(file.Name, r)
+			}()
+		default:
+			// Links may point outside the dependency, and aren't extracted
+			printer.From(ctx).Warn("Skipping %s in archive, of unsupported type", file.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package proj
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpdateArchiveDependency(t *testing.T) {
+	archives := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	for path, data := range map[string][]byte{
+		"/a.tar.gz": tarGzArchive(t, map[string]string{
+			"a-1.0.0/opa.project":   "source: src\ndependencies:\n  b: " + server.URL + "/b.zip\n",
+			"a-1.0.0/src/a.rego":    "package a\n\np := data.b.b.q\n",
+			"a-1.0.0/src/data.json": `{"x": 1}`,
+		}),
+		"/b.zip": zipArchive(t, map[string]string{
+			"policy.rego": "package b\n\nq := true\n",
+		}),
+		"/traversal.tar.gz": tarGzArchive(t, map[string]string{
+			"../escaped.rego": "package escaped\n",
+		}),
+		"/absolute.zip": zipArchive(t, map[string]string{
+			"/escaped.rego": "package escaped\n",
+		}),
+		"/c.tgz": tarGzArchive(t, map[string]string{
+			"c.rego": "package c\n",
+		}),
+		"/d.rar": []byte("not an archive"),
+	} {
+		archives[path] = data
+	}
+
+	checksum := func(path string) string {
+		return fmt.Sprintf("%x", sha256.Sum256(archives[path]))
+	}
+	// The checksum of an archive not served, and so never cached
+	otherChecksum := fmt.Sprintf("%x", sha256.Sum256([]byte("other")))
+
+	tests := []struct {
+		note          string
+		dependency    string
+		expectedFiles map[string]string
+		expectedErr   string
+	}{
+		{
+			note:       "tar.gz with transitive zip dependency",
+			dependency: server.URL + "/a.tar.gz",
+			expectedFiles: map[string]string{
+				"a/src/a.rego":        "package lib.a\n\np := data.lib.b.b.q\n",
+				"a/src/lib/data.json": `{"x": 1}`,
+				"b/policy.rego":       "package lib.b.b\n\nq := true\n",
+			},
+		},
+		{
+			note:       "matching checksum",
+			dependency: fmt.Sprintf("\n    location: %s/c.tgz\n    sha256: %s", server.URL, checksum("/c.tgz")),
+			expectedFiles: map[string]string{
+				"c/c.rego": "package lib.c\n",
+			},
+		},
+		{
+			note:        "mismatching checksum",
+			dependency:  fmt.Sprintf("\n    location: %s/c.tgz\n    sha256: sha256:%s", server.URL, otherChecksum),
+			expectedErr: fmt.Sprintf("checksum of archive %s/c.tgz does not match; expected sha256 %s, got %s", server.URL, otherChecksum, checksum("/c.tgz")),
+		},
+		{
+			note:        "invalid checksum",
+			dependency:  fmt.Sprintf("\n    location: %s/c.tgz\n    sha256: abc", server.URL),
+			expectedErr: "invalid sha256 checksum of dependency lib: abc",
+		},
+		{
+			note:        "path outside archive",
+			dependency:  server.URL + "/traversal.tar.gz",
+			expectedErr: "illegal path ../escaped.rego in archive",
+		},
+		{
+			note:        "absolute path",
+			dependency:  server.URL + "/absolute.zip",
+			expectedErr: "illegal absolute path /escaped.rego in archive",
+		},
+		{
+			note:        "not found",
+			dependency:  server.URL + "/missing.zip",
+			expectedErr: "failed to download " + server.URL + "/missing.zip: 404 Not Found",
+		},
+		{
+			note:        "unsupported format",
+			dependency:  server.URL + "/d.rar",
+			expectedErr: "unsupported archive format",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": "dependencies:\n  lib: " + tc.dependency + "\n",
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				lib := project.Dependencies["lib"]
+				libDir := lib.dir(dependenciesDir(path))
+				for file, expected := range tc.expectedFiles {
+					name, rest, _ := strings.Cut(file, "/")
+					dir := libDir
+					if name == "b" {
+						b := Dependency{
+							DependencyInfo:   DependencyInfo{Location: server.URL + "/b.zip", Namespace: "b"},
+							ParentDependency: &lib,
+						}
+						dir = b.dir(dependenciesDir(path))
+					}
+					expectFileContent(t, filepath.Join(dir, rest), expected)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateArchiveDependencyFromCache(t *testing.T) {
+	archive := tarGzArchive(t, map[string]string{
+		"cached.rego": "package cached\n",
+	})
+	sum := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		note       string
+		dependency string
+		opts       UpdateOptions
+	}{
+		{
+			note:       "declared checksum",
+			dependency: fmt.Sprintf("\n    location: %s/cached.tar.gz\n    sha256: %s", server.URL, sum),
+		},
+		{
+			note:       "locked checksum, offline",
+			dependency: server.URL + "/cached.tar.gz",
+			opts:       UpdateOptions{Offline: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": "dependencies:\n  lib: " + tc.dependency + "\n",
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+					t.Fatal(err)
+				}
+
+				mu.Lock()
+				before := requests
+				mu.Unlock()
+
+				project, err = ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(tc.opts); err != nil {
+					t.Fatal(err)
+				}
+				expectFileContent(t, filepath.Join(project.Dependencies["lib"].dir(dependenciesDir(path)), "cached.rego"),
+					"package lib.cached\n")
+
+				mu.Lock()
+				defer mu.Unlock()
+				if requests != before {
+					t.Fatalf("expected archive to be materialized from cache, got %d requests", requests-before)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateArchiveDependencyChanged(t *testing.T) {
+	var mu sync.Mutex
+	archive := tarGzArchive(t, map[string]string{
+		"policy.rego": "package p\n\nx := 1\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	files := map[string]string{
+		"opa.project": "dependencies:\n  lib: " + server.URL + "/lib.tar.gz\n",
+	}
+	err := withTempFiles(files, func(path string) {
+		readLock := func() string {
+			data, err := os.ReadFile(filepath.Join(path, lockFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		locked := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+		// The same location serves different content, and the locked archive is neither cached nor materialized
+		mu.Lock()
+		archive = tarGzArchive(t, map[string]string{
+			"policy.rego": "package p\n\nx := 2\n",
+		})
+		changed := fmt.Sprintf("%x", sha256.Sum256(archive))
+		mu.Unlock()
+		cached, err := cachedArchiveDir(locked, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(cached); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+			t.Fatal(err)
+		}
+
+		project, err = ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedErr := fmt.Sprintf("archive %s/lib.tar.gz has changed since it was locked; expected sha256 %s, got %s",
+			server.URL, locked, changed)
+		if err := project.Update(UpdateOptions{}); err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("expected error containing '%s', got %v", expectedErr, err)
+		}
+		if lock := readLock(); !strings.Contains(lock, locked) {
+			t.Fatalf("expected lock file to be unchanged, got:\n%s", lock)
+		}
+
+		project, err = ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{Refresh: true}); err != nil {
+			t.Fatal(err)
+		}
+		if lock := readLock(); !strings.Contains(lock, changed) {
+			t.Fatalf("expected lock file to contain refreshed checksum %s, got:\n%s", changed, lock)
+		}
+		expectFileContent(t, filepath.Join(project.Dependencies["lib"].dir(dependenciesDir(path)), "policy.rego"),
+			"package lib.p\n\nx := 2\n")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchivesBoundedTogether(t *testing.T) {
+	dir := t.TempDir()
+	layers := []map[string]string{
+		{"a.rego": "package a\n"},
+		{"b/b.rego": "package b\n"},
+	}
+
+	// As the layers of an OCI artifact, the archives are extracted by a single writer, bounding their total size
+	w := newArchiveWriter(filepath.Join(dir, "content"))
+	var expected int64
+	for i, layer := range layers {
+		archive := filepath.Join(dir, fmt.Sprintf("layer-%d.tar.gz", i))
+		if err := os.WriteFile(archive, tarGzArchive(t, layer), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTarGz(context.Background(), archive, w); err != nil {
+			t.Fatal(err)
+		}
+		for _, content := range layer {
+			expected += int64(len(content))
+		}
+	}
+
+	if w.written != expected {
+		t.Fatalf("expected %d bytes written across archives, got %d", expected, w.written)
+	}
+	expectFileContent(t, filepath.Join(dir, "content", "a.rego"), "package a\n")
+	expectFileContent(t, filepath.Join(dir, "content", "b", "b.rego"), "package b\n")
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedFileNames(files) {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range sortedFileNames(files) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sortedFileNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,201 @@
+package proj
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/open-policy-agent/opa/ast"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	bundlePrefix       = "bundle+"
+	bundleManifestFile = ".manifest"
+	// bundleSignaturesFile signs the files of a bundle, as built; and doesn't hold once the bundle is namespaced
+	bundleSignaturesFile = ".signatures.json"
+	bundlePlanFile       = "plan.json"
+)
+
+// isBundleLocation reports whether location is the location of an OPA bundle; a local file, or downloaded over
+// HTTP(S).
+func isBundleLocation(location string) bool {
+	if !strings.HasPrefix(location, bundlePrefix) {
+		return false
+	}
+	source := strings.TrimPrefix(location, bundlePrefix)
+	return strings.HasPrefix(source, "file:") || isHttpLocation(source)
+}
+
+// bundleManifest is the .manifest file of a bundle. Attributes other than roots are kept as they are.
+type bundleManifest map[string]interface{}
+
+func readBundleManifest(dir string) (bundleManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// roots returns the roots declared by the manifest, as slash-separated paths in data; or nil if none are declared.
+func (m bundleManifest) roots() ([]string, error) {
+	raw, ok := m["roots"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid bundle manifest: roots must be a list of strings")
+	}
+	roots := make([]string, 0, len(list))
+	for _, r := range list {
+		root, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid bundle manifest: roots must be a list of strings")
+		}
+		roots = append(roots, strings.Trim(root, "/"))
+	}
+	return roots, nil
+}
+
+// rootRefs returns the roots of the bundle manifest in dir, if any, as references in data.
+func bundleRootRefs(dir string) ([]ast.Ref, error) {
+	manifest, err := readBundleManifest(dir)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	roots, err := manifest.roots()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]ast.Ref, 0, len(roots))
+	for _, root := range roots {
+		ref := ast.DefaultRootRef.Copy()
+		if root != "" {
+			for _, segment := range strings.Split(root, "/") {
+				ref = append(ref, ast.StringTerm(segment))
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// prepareBundle prepares the bundle extracted into dir for being namespaced with namespace, and loaded along with the
+// project: the roots of its manifest are moved into the namespace, and the compiled Wasm modules and plans, and
+// signatures, it may contain are removed.
+// Bundles containing only compiled policies, without any Rego modules, can't be namespaced; and fail.
+func prepareBundle(ctx context.Context, dir, namespace string) error {
+	manifest, err := readBundleManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var rego int
+	compiled := map[string]bool{}
+	if manifest != nil {
+		if wasm, ok := manifest["wasm"].([]interface{}); ok {
+			for _, w := range wasm {
+				if module, ok := w.(map[string]interface{}); ok {
+					if file, ok := module["module"].(string); ok {
+						compiled[filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(file, "/")))] = true
+					}
+				}
+			}
+		}
+	}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case !entry.Type().IsRegular():
+		case strings.HasSuffix(entry.Name(), ".rego"):
+			rego++
+		case strings.HasSuffix(entry.Name(), ".wasm"):
+			compiled[path] = true
+		case path == filepath.Join(dir, bundlePlanFile):
+			compiled[path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list bundle files: %w", err)
+	}
+
+	if len(compiled) > 0 {
+		targets := compiledTargets(compiled)
+		if rego == 0 {
+			return fmt.Errorf("bundle contains only compiled %s policies, which can't be namespaced; depend on a bundle built for the rego target",
+				strings.Join(targets, " and "))
+		}
+		printer.From(ctx).Warn("Skipping compiled %s policies in bundle, using its Rego modules", strings.Join(targets, " and "))
+		for file := range compiled {
+			if err := os.RemoveAll(file); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(dir, bundleSignaturesFile)); err != nil {
+		return err
+	}
+
+	if manifest == nil {
+		return nil
+	}
+	delete(manifest, "wasm")
+	roots, err := manifest.roots()
+	if err != nil {
+		return err
+	}
+	if namespace != "" {
+		nsPath := strings.ReplaceAll(namespace, ".", "/")
+		if roots == nil {
+			roots = []string{""}
+		}
+		for i, root := range roots {
+			if root == "" {
+				roots[i] = nsPath
+			} else {
+				roots[i] = nsPath + "/" + root
+			}
+		}
+	}
+	if roots != nil {
+		manifest["roots"] = roots
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, bundleManifestFile), data, 0644)
+}
+
+func compiledTargets(files map[string]bool) []string {
+	found := map[string]bool{}
+	for file := range files {
+		if strings.HasSuffix(file, ".wasm") {
+			found["wasm"] = true
+		} else {
+			found["plan"] = true
+		}
+	}
+	targets := make([]string, 0, len(found))
+	for target := range found {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
@@ -0,0 +1,162 @@
+package proj
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateBundleDependency(t *testing.T) {
+	regoBundle := tarGzArchive(t, map[string]string{
+		".manifest":              `{"revision": "v1", "roots": ["authz"]}`,
+		".signatures.json":       `{"signatures": []}`,
+		"authz/policy.rego":      "package authz\n\nallow := data.authz.users[input.user]\n",
+		"authz/users/data.json":  `{"alice": true}`,
+		"authz/optimized/x.rego": "package authz.x\n",
+	})
+	wasmBundle := tarGzArchive(t, map[string]string{
+		".manifest":         `{"roots": ["authz"], "wasm": [{"entrypoint": "authz/allow", "module": "/policy.wasm"}]}`,
+		"policy.wasm":       "\x00asm",
+		"authz/policy.rego": "package authz\n\nallow := true\n",
+		"data.json":         `{}`,
+	})
+	wasmOnlyBundle := tarGzArchive(t, map[string]string{
+		".manifest":   `{"wasm": [{"entrypoint": "authz/allow", "module": "/policy.wasm"}]}`,
+		"policy.wasm": "\x00asm",
+		"data.json":   `{}`,
+	})
+	planOnlyBundle := tarGzArchive(t, map[string]string{
+		"plan.json": `{"plans": {}}`,
+	})
+	plainBundle := tarGzArchive(t, map[string]string{
+		"x/policy.rego": "package x\n",
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bundle servers don't necessarily name their bundles by file extension
+		if r.URL.Path != "/bundles/authz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(wasmBundle)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		note            string
+		files           map[string]string
+		dependency      string
+		expectedFiles   map[string]string
+		unexpectedFiles []string
+		expectedErr     string
+	}{
+		{
+			note: "local rego bundle",
+			files: map[string]string{
+				"bundle.tar.gz": string(regoBundle),
+			},
+			dependency: "bundle+file:/bundle.tar.gz",
+			expectedFiles: map[string]string{
+				"authz/policy.rego":         "package lib.authz\n\nallow := data.lib.authz.users[input.user]\n",
+				"authz/optimized/x.rego":    "package lib.authz.x\n",
+				"lib/authz/users/data.json": `{"alice": true}`,
+				".manifest": `{
+  "revision": "v1",
+  "roots": [
+    "lib/authz"
+  ]
+}`,
+			},
+			unexpectedFiles: []string{".signatures.json"},
+		},
+		{
+			note:       "remote bundle with wasm module",
+			dependency: "bundle+" + server.URL + "/bundles/authz",
+			expectedFiles: map[string]string{
+				"authz/policy.rego": "package lib.authz\n\nallow := true\n",
+				"lib/data.json":     `{}`,
+				".manifest": `{
+  "roots": [
+    "lib/authz"
+  ]
+}`,
+			},
+			unexpectedFiles: []string{"policy.wasm"},
+		},
+		{
+			note: "without namespace or manifest",
+			files: map[string]string{
+				"bundle.tar.gz": string(plainBundle),
+			},
+			dependency: "\n    location: bundle+file:/bundle.tar.gz\n    namespace: false",
+			expectedFiles: map[string]string{
+				"x/policy.rego": "package x\n",
+			},
+			unexpectedFiles: []string{".manifest"},
+		},
+		{
+			note: "wasm only",
+			files: map[string]string{
+				"bundle.tar.gz": string(wasmOnlyBundle),
+			},
+			dependency:  "bundle+file:/bundle.tar.gz",
+			expectedErr: "invalid bundle dependency lib: bundle contains only compiled wasm policies, which can't be namespaced",
+		},
+		{
+			note: "plan only",
+			files: map[string]string{
+				"bundle.tar.gz": string(planOnlyBundle),
+			},
+			dependency:  "bundle+file:/bundle.tar.gz",
+			expectedErr: "invalid bundle dependency lib: bundle contains only compiled plan policies, which can't be namespaced",
+		},
+		{
+			note: "overlapping bundle root",
+			files: map[string]string{
+				"bundle.tar.gz": string(regoBundle),
+				"a/policy.rego": "package authz.admin\n",
+			},
+			dependency:  "\n    location: bundle+file:/bundle.tar.gz\n    namespace: false\n  a:\n    location: file:/a\n    namespace: false",
+			expectedErr: "data.authz.admin is contributed to by both dependency a and dependency lib",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := copyFiles(tc.files)
+			files["opa.project"] = "dependencies:\n  lib: " + tc.dependency + "\n"
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				libDir := project.Dependencies["lib"].dir(dependenciesDir(path))
+				for file, expected := range tc.expectedFiles {
+					expectFileContent(t, filepath.Join(libDir, file), expected)
+				}
+				for _, file := range tc.unexpectedFiles {
+					if _, err := os.Stat(filepath.Join(libDir, file)); !os.IsNotExist(err) {
+						t.Fatalf("expected %s to be removed, got %v", file, err)
+					}
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package proj
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// errNotCached is returned when an offline update needs content that isn't in the dependency cache.
+var errNotCached = errors.New("not found in dependency cache")
+
+const (
+	cacheDirEnv  = "ODM_CACHE_DIR"
+	cacheGitDir  = "git"
+	cacheTreeDir = "trees"
+)
+
+// cacheDir returns the root directory of the user-level dependency cache, shared by all projects.
+// The location can be overridden through the ODM_CACHE_DIR environment variable.
+func cacheDir() (string, error) {
+	if dir, ok := os.LookupEnv(cacheDirEnv); ok && dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user cache directory; set %s to configure the cache location: %w",
+			cacheDirEnv, err)
+	}
+	return filepath.Join(dir, "odm"), nil
+}
+
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(parts, "\x00")))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// gitCacheLocks holds a mutex per git url, as concurrent updates must not fetch into the same cached repository at once.
+var gitCacheLocks sync.Map
+
+// lockCachedGitRepository locks the cached git repository for url, and returns the function unlocking it.
+func lockCachedGitRepository(url string) func() {
+	m, _ := gitCacheLocks.LoadOrStore(url, &sync.Mutex{})
+	m.(*sync.Mutex).Lock()
+	return m.(*sync.Mutex).Unlock
+}
+
+// cachedGitRepository opens the cached bare git repository holding all objects fetched from url.
+// If the repository doesn't exist, it's initialized if create is set; otherwise errNotCached is returned.
+func cachedGitRepository(ctx context.Context, url string, create bool) (*git.Repository, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, cacheGitDir, cacheKey(url))
+
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if !create {
+			return nil, fmt.Errorf("git repository %s %w", url, errNotCached)
+		}
+		printer.From(ctx).Debug("Initializing cached git repository %s for %s", dir, url)
+		repo, err = git.PlainInit(dir, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached git repository %s: %w", dir, err)
+	}
+
+	if _, err := repo.Remote("origin"); errors.Is(err, git.ErrRemoteNotFound) {
+		if _, err := repo.CreateRemote(&config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{url},
+		}); err != nil {
+			return nil, fmt.Errorf("failed to configure cached git repository %s: %w", dir, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open cached git repository %s: %w", dir, err)
+	}
+
+	return repo, nil
+}
+
+// hasCachedGitCommit reports whether the cached git repository for url contains the commit hash.
+func hasCachedGitCommit(ctx context.Context, url string, hash plumbing.Hash) bool {
+	unlock := lockCachedGitRepository(url)
+	defer unlock()
+
+	repo, err := cachedGitRepository(ctx, url, false)
+	if err != nil {
+		return false
+	}
+	_, err = repo.CommitObject(hash)
+	return err == nil
+}
+
+// cachedGitRefs lists the branches and tags of the cached git repository for url, as last fetched, named as they are
+// in the remote repository.
+func cachedGitRefs(ctx context.Context, url string) ([]*plumbing.Reference, error) {
+	unlock := lockCachedGitRepository(url)
+	defer unlock()
+
+	repo, err := cachedGitRepository(ctx, url, false)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references of cached git repository %s: %w", url, err)
+	}
+
+	var refs []*plumbing.Reference
+	remoteBranchPrefix := plumbing.NewRemoteReferenceName("origin", "").String()
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		switch name := ref.Name(); {
+		case strings.HasPrefix(name.String(), remoteBranchPrefix) && ref.Type() == plumbing.HashReference:
+			branch := plumbing.NewBranchReferenceName(strings.TrimPrefix(name.String(), remoteBranchPrefix))
+			refs = append(refs, plumbing.NewHashReference(branch, ref.Hash()))
+		case name.IsTag():
+			refs = append(refs, ref)
+			if tag, err := repo.TagObject(ref.Hash()); err == nil {
+				if commit, err := tag.Commit(); err == nil {
+					refs = append(refs, plumbing.NewHashReference(name+peeledSuffix, commit.Hash))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references of cached git repository %s: %w", url, err)
+	}
+	return refs, nil
+}
+
+// fetchCachedGitRepository fetches all branches and tags from url into its cached git repository.
+func fetchCachedGitRepository(ctx context.Context, url string, repo *git.Repository) error {
+	printer.From(ctx).Debug("Fetching git repository %s", url)
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs: []config.RefSpec{
+			"+refs/heads/*:refs/remotes/origin/*",
+			"+refs/tags/*:refs/tags/*",
+		},
+		Progress: printer.From(ctx).DebugPrinter(),
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch git repository %s: %w", url, err)
+	}
+	return nil
+}
+
+// cachedTreeDir returns the cache directory holding the source tree of the git commit revision, rooted at subdir.
+// As commit SHAs address their content, the directory is shared by all repositories containing the commit.
+func cachedTreeDir(revision plumbing.Hash, subdir string) (string, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, cacheTreeDir, cacheKey(revision.String(), subdir)), nil
+}
+
+// cacheGitTree writes tree into the cache, as the source tree of revision rooted at subdir, and returns its directory.
+// The tree is written to a temporary directory first, so that an interrupted write never leaves a partial tree behind.
+func cacheGitTree(ctx context.Context, tree *object.Tree, revision plumbing.Hash, subdir string) (string, error) {
+	dir, err := cachedTreeDir(revision, subdir)
+	if err != nil {
+		return "", err
+	}
+	if utils.FileExists(dir) {
+		return dir, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	if err := writeGitTree(ctx, tree, tmpDir); err != nil {
+		return "", fmt.Errorf("failed to write source tree of revision %s to cache: %w", revision, err)
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil && !utils.FileExists(dir) {
+		return "", fmt.Errorf("failed to write source tree of revision %s to cache: %w", revision, err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,242 @@
+package proj
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep tests from reading or writing the user-level dependency cache
+	dir, err := os.MkdirTemp("", "odm-cache-")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_ = os.Setenv(cacheDirEnv, dir)
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestUpdateFromCache(t *testing.T) {
+	tests := []struct {
+		note        string
+		dependency  func(revision string) string
+		clearTrees  bool
+		expectedErr string
+	}{
+		{
+			note: "locked tag from cached source tree",
+			dependency: func(string) string {
+				return "#v1"
+			},
+		},
+		{
+			note: "locked HEAD from cached source tree",
+			dependency: func(string) string {
+				return ""
+			},
+		},
+		{
+			note: "commit from cached source tree",
+			dependency: func(revision string) string {
+				return "\n    commit: " + revision
+			},
+		},
+		{
+			note: "abbreviated commit from cached git repository",
+			dependency: func(revision string) string {
+				return "\n    commit: " + revision[:7]
+			},
+			clearTrees: true,
+		},
+		{
+			note: "locked tag not cached",
+			dependency: func(string) string {
+				return "#v1"
+			},
+			clearTrees:  true,
+			expectedErr: "failed to clone git repository",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			cache := t.TempDir()
+			t.Setenv(cacheDirEnv, cache)
+
+			repoDir := t.TempDir()
+			repo := initGitRepo(t, repoDir)
+			revision := commitFile(t, repo, "policy.rego", "package lib\n")
+			tagCommit(t, repo, "v1", revision)
+
+			files := map[string]string{
+				"opa.project": fmt.Sprintf("dependencies:\n  lib:\n    location: git+file://%s%s\n    namespace: false\n",
+					repoDir, tc.dependency(revision)),
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+
+				// The remote repository is no longer reachable, and the dependency directory is gone, so the dependency
+				// can only be materialized from the cache
+				if err := os.RemoveAll(repoDir); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+					t.Fatal(err)
+				}
+				if tc.clearTrees {
+					if err := os.RemoveAll(filepath.Join(cache, cacheTreeDir)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				dep := project.Dependencies["lib"]
+				expectFileContent(t, filepath.Join(dep.dir(dependenciesDir(path)), "policy.rego"), "package lib\n")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOfflineUpdate(t *testing.T) {
+	tag := func(string) string {
+		return "#v1"
+	}
+	commit := func(revision string) string {
+		return "\n    commit: " + revision[:7]
+	}
+
+	tests := []struct {
+		note string
+		// online is the dependency declared for an initial update, before the remote repository is removed; if any
+		online      func(revision string) string
+		offline     func(revision string) string
+		clearCache  bool
+		expectedErr string
+	}{
+		{
+			note:    "locked tag from cached source tree",
+			online:  tag,
+			offline: tag,
+		},
+		{
+			note:   "branch from cached git repository",
+			online: commit,
+			offline: func(string) string {
+				return "\n    branch: master"
+			},
+		},
+		{
+			note:   "version constraint from cached git repository",
+			online: commit,
+			offline: func(string) string {
+				return "\n    version: ^1.0.0"
+			},
+		},
+		{
+			note:       "locked tag from materialized directory",
+			online:     tag,
+			offline:    tag,
+			clearCache: true,
+		},
+		{
+			note:        "not cached",
+			offline:     tag,
+			expectedErr: "dependency lib (git+file://%s#v1) is not available offline: git repository file://%s not found in dependency cache",
+		},
+		{
+			note:   "HEAD not cached",
+			online: commit,
+			offline: func(string) string {
+				return ""
+			},
+			expectedErr: "dependency lib (git+file://%s) is not available offline: HEAD of git repository file://%s not found in dependency cache",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			cache := t.TempDir()
+			t.Setenv(cacheDirEnv, cache)
+
+			repoDir := t.TempDir()
+			repo := initGitRepo(t, repoDir)
+			revision := commitFile(t, repo, "policy.rego", "package lib\n")
+			tagCommit(t, repo, "v1", revision)
+
+			projectFile := func(dependency string) string {
+				return fmt.Sprintf("dependencies:\n  lib:\n    location: git+file://%s%s\n    namespace: false\n",
+					repoDir, dependency)
+			}
+
+			err := withTempFiles(map[string]string{}, func(path string) {
+				update := func(dependency string, opts UpdateOptions) (*Project, error) {
+					if err := os.WriteFile(filepath.Join(path, "opa.project"), []byte(projectFile(dependency)), 0644); err != nil {
+						t.Fatal(err)
+					}
+					project, err := ReadProjectFromFile(path, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return project, project.Update(opts)
+				}
+
+				if tc.online != nil {
+					if _, err := update(tc.online(revision), UpdateOptions{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.RemoveAll(repoDir); err != nil {
+					t.Fatal(err)
+				}
+				if tc.clearCache {
+					if err := os.RemoveAll(cache); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				project, err := update(tc.offline(revision), UpdateOptions{Offline: true})
+				if tc.expectedErr != "" {
+					expected := fmt.Sprintf(tc.expectedErr, repoDir, repoDir)
+					if err == nil || !strings.Contains(err.Error(), expected) {
+						t.Fatalf("expected error containing '%s', got %v", expected, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				dep := project.Dependencies["lib"]
+				expectFileContent(t, filepath.Join(dep.dir(dependenciesDir(path)), "policy.rego"), "package lib\n")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
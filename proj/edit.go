@@ -0,0 +1,487 @@
+package proj
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// errUnsupportedLayout is returned by in-place edits of project file content laid out in a way they can't edit,
+// such as flow style mappings.
+var errUnsupportedLayout = errors.New("unsupported layout")
+
+// projectFile is the content of a project file, as read from disk, that mutations of the project are applied to.
+// Edits rewrite only the lines of the entries they change, so comments, ordering, and formatting of the rest of the
+// file survive. Where the layout doesn't allow that, the YAML node tree is edited and re-encoded instead, which still
+// keeps comments and ordering.
+type projectFile struct {
+	data []byte
+	doc  yaml.Node
+}
+
+func parseProjectFile(data []byte) (*projectFile, error) {
+	f := &projectFile{data: data}
+	if err := f.parse(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *projectFile) parse() error {
+	f.doc = yaml.Node{}
+	if err := yaml.Unmarshal(f.data, &f.doc); err != nil {
+		return fmt.Errorf("failed to parse project file: %w", err)
+	}
+	return nil
+}
+
+// setDependency declares dep in the dependencies mapping, replacing any declaration with the same name.
+func (f *projectFile) setDependency(dep Dependency) error {
+	value, err := dep.MarshalYAML()
+	if err != nil {
+		return err
+	}
+	return f.edit(func() error {
+		return f.set([]string{"dependencies", dep.Name}, value)
+	}, func() error {
+		return f.setNode([]string{"dependencies", dep.Name}, value)
+	})
+}
+
+// removeDependency removes the declaration of the dependency name; and the dependencies mapping, if left empty.
+func (f *projectFile) removeDependency(name string) error {
+	return f.edit(func() error {
+		return f.remove([]string{"dependencies", name})
+	}, func() error {
+		return f.removeNode([]string{"dependencies", name})
+	})
+}
+
+// edit applies the in-place edit, falling back to editing the node tree if the layout doesn't allow it.
+func (f *projectFile) edit(inPlace func() error, node func() error) error {
+	err := inPlace()
+	if !errors.Is(err, errUnsupportedLayout) {
+		return err
+	}
+	if err := node(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(f.indent())
+	if err := enc.Encode(&f.doc); err != nil {
+		return fmt.Errorf("failed to encode project file: %w", err)
+	}
+	f.data = buf.Bytes()
+	return f.parse()
+}
+
+// set sets the value at path, creating any missing mappings along it.
+func (f *projectFile) set(path []string, value interface{}) error {
+	key := path[len(path)-1]
+	parent := f.lookup(path[:len(path)-1])
+	if parent == nil || isNull(parent) {
+		if len(path) > 1 {
+			return f.set(path[:len(path)-1], map[string]interface{}{key: value})
+		}
+		if parent == nil {
+			// Empty, or comments only
+			return f.insert(len(f.lines())+1, key, value, "")
+		}
+	}
+	if parent.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", strings.Join(path[:len(path)-1], "."))
+	}
+
+	i := keyIndex(parent, key)
+	if i >= 0 {
+		var current interface{}
+		if err := parent.Content[i+1].Decode(&current); err == nil && reflect.DeepEqual(current, value) {
+			return nil
+		}
+	}
+	if parent.Style&yaml.FlowStyle != 0 {
+		return errUnsupportedLayout
+	}
+
+	indent := strings.Repeat(" ", parent.Content[0].Column-1)
+	if i < 0 {
+		return f.insert(f.entryEnd(parent, len(parent.Content)-2), key, value, indent)
+	}
+
+	old := parent.Content[i+1]
+
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+
+	if old.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode && !isNull(old) {
+		if scalarFits(old, value) {
+			data, err := replaceScalar(f.data, old, node.Value)
+			if err != nil {
+				return err
+			}
+			f.data = data
+			return f.parse()
+		}
+	}
+
+	if m, ok := value.(map[string]interface{}); ok && old.Kind == yaml.MappingNode {
+		if old.Style&yaml.FlowStyle != 0 {
+			return errUnsupportedLayout
+		}
+		// Keys are set in the order already declared, followed by new ones
+		var keys, removed []string
+		for j := 0; j+1 < len(old.Content); j += 2 {
+			if _, ok := m[old.Content[j].Value]; ok {
+				keys = append(keys, old.Content[j].Value)
+			} else {
+				removed = append(removed, old.Content[j].Value)
+			}
+		}
+		var added []string
+		for k := range m {
+			if keyIndex(old, k) < 0 {
+				added = append(added, k)
+			}
+		}
+		sort.Strings(added)
+		for _, k := range append(keys, added...) {
+			if err := f.set(append(path[:len(path):len(path)], k), m[k]); err != nil {
+				return err
+			}
+		}
+		for _, k := range removed {
+			if err := f.remove(append(path[:len(path):len(path)], k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Head comments of the replaced entry are kept
+	return f.replace(parent.Content[i].Line, f.entryEnd(parent, i), key, value, indent)
+}
+
+// remove removes the entry at path, if present; and its parent mapping, if left empty.
+func (f *projectFile) remove(path []string) error {
+	key := path[len(path)-1]
+	parent := f.lookup(path[:len(path)-1])
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return nil
+	}
+	i := keyIndex(parent, key)
+	if i < 0 {
+		return nil
+	}
+	if parent.Style&yaml.FlowStyle != 0 {
+		return errUnsupportedLayout
+	}
+	if len(parent.Content) == 2 && len(path) > 1 {
+		return f.remove(path[:len(path)-1])
+	}
+
+	lines := f.lines()
+	start, end := headStart(lines, parent.Content[i]), f.entryEnd(parent, i)
+	// An entry separated by blank lines on both sides leaves one of them behind
+	if start > 1 && end <= len(lines) && isBlank(lines[start-2]) && isBlank(lines[end-1]) {
+		end++
+	}
+	f.data = append(append([]byte{}, f.data[:lineOffset(lines, start)]...), f.data[lineOffset(lines, end):]...)
+	return f.parse()
+}
+
+// insert inserts the entry key: value, at indent, before line.
+func (f *projectFile) insert(line int, key string, value interface{}, indent string) error {
+	return f.replace(line, line, key, value, indent)
+}
+
+// replace replaces the lines from start up to, but not including, end with the entry key: value, at indent.
+func (f *projectFile) replace(start, end int, key string, value interface{}, indent string) error {
+	entry, err := f.render(key, value, indent)
+	if err != nil {
+		return err
+	}
+	lines := f.lines()
+	from, to := lineOffset(lines, start), lineOffset(lines, end)
+	if from > 0 && f.data[from-1] != '\n' {
+		entry = append([]byte("\n"), entry...)
+	}
+
+	data := make([]byte, 0, len(f.data)-(to-from)+len(entry))
+	data = append(data, f.data[:from]...)
+	data = append(data, entry...)
+	f.data = append(data, f.data[to:]...)
+	return f.parse()
+}
+
+// render renders the entry key: value, with every line prefixed by indent.
+func (f *projectFile) render(key string, value interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(f.indent())
+	if err := enc.Encode(map[string]interface{}{key: value}); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	var entry []byte
+	for _, line := range bytes.SplitAfter(buf.Bytes(), []byte("\n")) {
+		if len(line) > 0 {
+			entry = append(entry, indent...)
+			entry = append(entry, line...)
+		}
+	}
+	return entry, nil
+}
+
+// setNode is the node tree counterpart of set.
+func (f *projectFile) setNode(path []string, value interface{}) error {
+	node := documentRoot(&f.doc)
+	if node == nil {
+		node = &yaml.Node{Kind: yaml.MappingNode}
+		f.doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}
+	}
+	for _, key := range path[:len(path)-1] {
+		next := mappingValue(node, key)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+		} else if isNull(next) {
+			*next = yaml.Node{Kind: yaml.MappingNode}
+		}
+		node = next
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", strings.Join(path[:len(path)-1], "."))
+	}
+
+	var v yaml.Node
+	if err := v.Encode(value); err != nil {
+		return err
+	}
+	key := path[len(path)-1]
+	if i := keyIndex(node, key); i >= 0 {
+		v.LineComment = node.Content[i+1].LineComment
+		node.Content[i+1] = &v
+	} else {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &v)
+	}
+	return nil
+}
+
+// removeNode is the node tree counterpart of remove.
+func (f *projectFile) removeNode(path []string) error {
+	var parents []*yaml.Node
+	node := documentRoot(&f.doc)
+	for _, key := range path {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		parents = append(parents, node)
+		node = mappingValue(node, key)
+	}
+	if node == nil {
+		return nil
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		parent := parents[i]
+		j := keyIndex(parent, path[i])
+		parent.Content = append(parent.Content[:j], parent.Content[j+2:]...)
+		if len(parent.Content) > 0 || i == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// lookup returns the node at path; nil if there is none.
+func (f *projectFile) lookup(path []string) *yaml.Node {
+	node := documentRoot(&f.doc)
+	for _, key := range path {
+		node = mappingValue(node, key)
+	}
+	return node
+}
+
+// indent returns the number of spaces nested mappings are indented by in the file; 2, if none are.
+func (f *projectFile) indent() int {
+	var indent func(node *yaml.Node) int
+	indent = func(node *yaml.Node) int {
+		if node.Kind != yaml.MappingNode || node.Style&yaml.FlowStyle != 0 {
+			return 0
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.MappingNode && value.Style&yaml.FlowStyle == 0 && len(value.Content) > 0 &&
+				value.Content[0].Column > key.Column {
+				return value.Content[0].Column - key.Column
+			}
+			if n := indent(value); n > 0 {
+				return n
+			}
+		}
+		return 0
+	}
+	if root := documentRoot(&f.doc); root != nil {
+		if n := indent(root); n > 0 {
+			return n
+		}
+	}
+	return 2
+}
+
+func (f *projectFile) lines() [][]byte {
+	lines := bytes.SplitAfter(f.data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// entryEnd returns the line following the i:th entry of the block mapping, including any comments nested below it.
+func (f *projectFile) entryEnd(mapping *yaml.Node, i int) int {
+	lines := f.lines()
+	end := lastLine(mapping.Content[i+1]) + 1
+	if key := mapping.Content[i]; key.Line >= end {
+		end = key.Line + 1
+	}
+	indent := mapping.Content[i].Column - 1
+	for end <= len(lines) {
+		line := lines[end-1]
+		trimmed := bytes.TrimLeft(line, " \t")
+		if !bytes.HasPrefix(trimmed, []byte("#")) || len(line)-len(trimmed) <= indent {
+			break
+		}
+		end++
+	}
+	return end
+}
+
+// headStart returns the first line of the comments directly above, and at the same indentation as, key; or the line
+// of key, if there are none.
+func headStart(lines [][]byte, key *yaml.Node) int {
+	start := key.Line
+	for start > 1 {
+		line := lines[start-2]
+		trimmed := bytes.TrimLeft(line, " ")
+		if !bytes.HasPrefix(trimmed, []byte("#")) || len(line)-len(trimmed) != key.Column-1 {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+// lastLine returns the last line spanned by node.
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	if node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		last += len(strings.Split(strings.TrimSuffix(node.Value, "\n"), "\n"))
+	}
+	for _, child := range node.Content {
+		if l := lastLine(child); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+// lineOffset returns the offset of the start of line in the content split into lines.
+func lineOffset(lines [][]byte, line int) int {
+	offset := 0
+	for i := 0; i < line-1 && i < len(lines); i++ {
+		offset += len(lines[i])
+	}
+	return offset
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0
+}
+
+func keyIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// scalarFits returns true if value can replace the value of the scalar node in place, keeping its style.
+func scalarFits(node *yaml.Node, value interface{}) bool {
+	s, ok := value.(string)
+	switch node.Style {
+	case 0:
+		out, err := yaml.Marshal(value)
+		return err == nil && strings.TrimSuffix(string(out), "\n") == fmt.Sprint(value)
+	case yaml.DoubleQuotedStyle:
+		return ok && !strings.ContainsAny(s, "\"\\\n")
+	case yaml.SingleQuotedStyle:
+		return ok && !strings.ContainsAny(s, "'\n")
+	}
+	return false
+}
+
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping node; nil if node isn't a mapping, or doesn't contain key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	if i := keyIndex(node, key); i >= 0 {
+		return node.Content[i+1]
+	}
+	return nil
+}
+
+// replaceScalar replaces the value of the single-line scalar node in data, keeping its quoting style.
+func replaceScalar(data []byte, node *yaml.Node, value string) ([]byte, error) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil, fmt.Errorf("invalid position of value '%s'", node.Value)
+	}
+	offset := lineOffset(lines, node.Line)
+	// Columns count characters, not bytes
+	line := lines[node.Line-1]
+	for column := 1; column < node.Column && len(line) > 0; column++ {
+		_, size := utf8.DecodeRune(line)
+		line = line[size:]
+		offset += size
+	}
+
+	var quote string
+	switch node.Style {
+	case 0:
+	case yaml.DoubleQuotedStyle:
+		quote = `"`
+	case yaml.SingleQuotedStyle:
+		quote = "'"
+	default:
+		return nil, fmt.Errorf("unsupported style of value '%s'", node.Value)
+	}
+	old := quote + node.Value + quote
+	if !bytes.HasPrefix(line, []byte(old)) {
+		return nil, fmt.Errorf("unsupported formatting of value '%s'", node.Value)
+	}
+
+	updated := make([]byte, 0, len(data)-len(old)+len(value)+2*len(quote))
+	updated = append(updated, data[:offset]...)
+	updated = append(updated, quote+value+quote...)
+	return append(updated, data[offset+len(old):]...), nil
+}
@@ -0,0 +1,377 @@
+package proj
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEditProjectFile(t *testing.T) {
+	type edit struct {
+		remove string
+		set    string
+		info   DependencyInfo
+	}
+
+	tests := []struct {
+		note     string
+		project  string
+		edits    []edit
+		expected string
+	}{
+		{
+			note: "add dependency",
+			project: `# Policies shared by all services
+name: services
+
+dependencies:
+  # Pinned until the next release
+  lib: git+https://example.com/lib.git#v1.0.0 # trailing comment
+
+source: src
+`,
+			edits: []edit{
+				{set: "local", info: DependencyInfo{Location: "file:/local", Namespace: "local"}},
+				{set: "other", info: DependencyInfo{Location: "file:/other", Namespace: "x"}},
+			},
+			expected: `# Policies shared by all services
+name: services
+
+dependencies:
+  # Pinned until the next release
+  lib: git+https://example.com/lib.git#v1.0.0 # trailing comment
+  local: file:/local
+  other:
+    location: file:/other
+    namespace: x
+
+source: src
+`,
+		},
+		{
+			note: "add first dependency",
+			project: `name: none
+source: src # policies
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/a", Namespace: "a"}}},
+			expected: `name: none
+source: src # policies
+dependencies:
+  a: file:/a
+`,
+		},
+		{
+			note: "add to empty dependencies",
+			project: `# To be declared
+dependencies:
+source: src
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/a", Namespace: "a"}}},
+			expected: `# To be declared
+dependencies:
+  a: file:/a
+source: src
+`,
+		},
+		{
+			note:     "add to empty file",
+			project:  "# Nothing yet",
+			edits:    []edit{{set: "a", info: DependencyInfo{Location: "file:/a"}}},
+			expected: "# Nothing yet\ndependencies:\n  a:\n    location: file:/a\n    namespace: false\n",
+		},
+		{
+			note: "replace location",
+			project: `dependencies:
+  a: "file:/a" # quoted
+  b: file:/b
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/c", Namespace: "a"}}},
+			expected: `dependencies:
+  a: "file:/c" # quoted
+  b: file:/b
+`,
+		},
+		{
+			note: "short to long form",
+			project: `dependencies:
+    # The a library
+    a: file:/a
+    b: file:/b
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/a", Namespace: "lib"}}},
+			expected: `dependencies:
+    # The a library
+    a:
+        location: file:/a
+        namespace: lib
+    b: file:/b
+`,
+		},
+		{
+			note: "long form attributes",
+			project: `dependencies:
+  a:
+    # Moved from file:/old
+    location: file:/a
+    namespace: lib
+    # Needed by the build
+    path: policies
+    tag: v1
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/a", Namespace: "", Tag: "v2"}}},
+			expected: `dependencies:
+  a:
+    # Moved from file:/old
+    location: file:/a
+    namespace: false
+    tag: v2
+`,
+		},
+		{
+			note: "long to short form",
+			project: `dependencies:
+  a:
+    location: file:/a
+    namespace: lib
+  b: file:/b
+`,
+			edits: []edit{{set: "a", info: DependencyInfo{Location: "file:/a", Namespace: "a"}}},
+			expected: `dependencies:
+  a: file:/a
+  b: file:/b
+`,
+		},
+		{
+			note: "remove dependency",
+			project: `dependencies:
+  a:
+    location: file:/a
+    # namespace: lib
+
+  # The b library
+  b: file:/b # local
+
+  c: file:/c
+# Build settings
+build:
+  target: wasm
+`,
+			edits: []edit{{remove: "b"}, {remove: "a"}},
+			expected: `dependencies:
+
+  c: file:/c
+# Build settings
+build:
+  target: wasm
+`,
+		},
+		{
+			note: "remove last dependency",
+			project: `name: services
+# Dependencies
+dependencies:
+  a: file:/a
+source: src
+`,
+			edits: []edit{{remove: "a"}},
+			expected: `name: services
+source: src
+`,
+		},
+		{
+			note: "flow style",
+			project: `# Flow style is kept
+source: [src]
+dependencies: {a: "file:/a", b: file:/b} # local
+`,
+			edits: []edit{
+				{remove: "a"},
+				{set: "c", info: DependencyInfo{Location: "file:/c", Namespace: "c"}},
+			},
+			expected: `# Flow style is kept
+source: [src]
+dependencies: {b: 'file:/b', c: 'file:/c'} # local
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": tc.project,
+			}
+			err := withTempFiles(files, func(root string) {
+				project, err := ReadProjectFromFile(root, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range tc.edits {
+					if e.remove != "" {
+						_, err = project.RemoveDependency(e.remove)
+					} else {
+						err = project.SetDependency(e.set, e.info)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := project.WriteToFile(root, true); err != nil {
+					t.Fatal(err)
+				}
+
+				actual, err := os.ReadFile(filepath.Join(root, "opa.project"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(actual) != tc.expected {
+					t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestEditProjectFileCorpus round-trips the project files in testdata/project-files through edits, checking that
+// the edited files declare what the project does, and that no other lines of the files are lost or reordered.
+func TestEditProjectFileCorpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "project-files", "*.project"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no project files found")
+	}
+
+	added := map[string]DependencyInfo{
+		"added":      {Location: "file:/added", Namespace: "added"},
+		"added_long": {Location: "git+https://example.com/added.git", Namespace: "", Branch: "main"},
+	}
+
+	for _, path := range paths {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Flow style layouts are re-encoded, and not kept line by line
+		flow := strings.Contains(filepath.Base(path), "flow")
+
+		read := func(t *testing.T) *Project {
+			t.Helper()
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "opa.project"), original, 0644); err != nil {
+				t.Fatal(err)
+			}
+			project, err := ReadProjectFromFile(dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return project
+		}
+		reread := func(t *testing.T, project *Project) *Project {
+			t.Helper()
+			dir := t.TempDir()
+			if err := project.WriteToFile(dir, true); err != nil {
+				t.Fatal(err)
+			}
+			written, err := ReadProjectFromFile(dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written.filePath, written.file = project.filePath, project.file
+			return written
+		}
+
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			t.Run("unchanged", func(t *testing.T) {
+				project := read(t)
+				for _, name := range project.dependencyNames() {
+					if err := project.SetDependency(name, project.Dependencies[name].DependencyInfo); err != nil {
+						t.Fatal(err)
+					}
+				}
+				written := reread(t, project)
+				if string(written.file.data) != string(original) {
+					t.Fatalf("expected:\n%s\ngot:\n%s", original, written.file.data)
+				}
+			})
+
+			t.Run("remove", func(t *testing.T) {
+				for _, name := range read(t).dependencyNames() {
+					project := read(t)
+					if _, err := project.RemoveDependency(name); err != nil {
+						t.Fatal(err)
+					}
+					written := reread(t, project)
+					if !reflect.DeepEqual(written, project) {
+						t.Fatalf("removing %s, expected:\n%+v\ngot:\n%+v", name, project, written)
+					}
+					if !flow && !isSubsequence(lines(written.file.data), lines(original)) {
+						t.Fatalf("removing %s, lines changed:\n%s", name, written.file.data)
+					}
+				}
+			})
+
+			t.Run("add", func(t *testing.T) {
+				project := read(t)
+				for _, name := range sortedKeys(added) {
+					if err := project.SetDependency(name, added[name]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				written := reread(t, project)
+				if !reflect.DeepEqual(written, project) {
+					t.Fatalf("expected:\n%+v\ngot:\n%+v", project, written)
+				}
+				if !flow && !isSubsequence(lines(original), lines(written.file.data)) {
+					t.Fatalf("lines lost:\n%s", written.file.data)
+				}
+			})
+
+			t.Run("replace", func(t *testing.T) {
+				project := read(t)
+				for _, name := range project.dependencyNames() {
+					info := project.Dependencies[name].DependencyInfo
+					info.Location += "-replaced"
+					info.Namespace = "replaced_" + name
+					if err := project.SetDependency(name, info); err != nil {
+						t.Fatal(err)
+					}
+				}
+				written := reread(t, project)
+				if !reflect.DeepEqual(written, project) {
+					t.Fatalf("expected:\n%+v\ngot:\n%+v", project, written)
+				}
+			})
+		})
+	}
+}
+
+func lines(data []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// isSubsequence returns true if all elements of a are found, in order, in b.
+func isSubsequence(a, b []string) bool {
+	i := 0
+	for _, s := range b {
+		if i < len(a) && a[i] == s {
+			i++
+		}
+	}
+	return i == len(a)
+}
+
+func sortedKeys(m map[string]DependencyInfo) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,624 @@
+package proj
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	RefTypeTag    = "tag"
+	RefTypeBranch = "branch"
+	RefTypeCommit = "commit"
+)
+
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// peeledSuffix is appended to the name of an annotated tag, when listing the commit it points to
+const peeledSuffix = "^{}"
+
+// gitRef is a git ref, either as declared for a dependency, or as resolved.
+// A declared ref without a type is resolved as a tag, branch, or commit; in that order.
+type gitRef struct {
+	typ  string
+	name string
+}
+
+// revision returns the git revision of the ref in a repository cloned from its remote.
+func (r gitRef) revision() plumbing.Revision {
+	switch r.typ {
+	case RefTypeTag:
+		return plumbing.Revision(plumbing.NewTagReferenceName(r.name))
+	case RefTypeBranch:
+		return plumbing.Revision(plumbing.NewRemoteReferenceName("origin", r.name))
+	default:
+		return plumbing.Revision(r.name)
+	}
+}
+
+func (r gitRef) String() string {
+	if r.typ == "" {
+		return r.name
+	}
+	return fmt.Sprintf("%s '%s'", r.typ, r.name)
+}
+
+// gitRef splits the location of a git dependency into its repository url, and either a literal ref or a version
+// constraint. The ref is declared either in the location, after the '#' separator, or by one of the dependency's ref,
+// tag, branch, or commit attributes. The version constraint is declared either in the location, or by the
+// dependency's version attribute.
+func (d Dependency) gitRef() (url string, ref gitRef, constraint string, err error) {
+	url, _, err = d.gitRepository()
+	if err != nil {
+		return "", gitRef{}, "", err
+	}
+	_, name, _ := parseGitUrl(d.Location)
+
+	var declared []string
+	if name != "" {
+		declared = append(declared, "location ref")
+		if isVersionConstraint(name) {
+			constraint = name
+		} else {
+			ref = gitRef{name: name}
+		}
+	}
+	for _, attr := range []struct {
+		name  string
+		value string
+		typ   string
+	}{
+		{"version", d.Version, ""},
+		{"ref", d.Ref, ""},
+		{"tag", d.Tag, RefTypeTag},
+		{"branch", d.Branch, RefTypeBranch},
+		{"commit", d.Commit, RefTypeCommit},
+	} {
+		if attr.value == "" {
+			continue
+		}
+		declared = append(declared, attr.name)
+		if attr.name == "version" {
+			constraint = attr.value
+		} else {
+			ref = gitRef{typ: attr.typ, name: attr.value}
+		}
+	}
+
+	if len(declared) > 1 {
+		return "", gitRef{}, "", fmt.Errorf("dependency %s declares conflicting git refs: %s",
+			d.Name, strings.Join(declared, ", "))
+	}
+
+	return url, ref, constraint, nil
+}
+
+// requested returns the git ref or version constraint declared for the dependency outside its location, if any.
+func (d Dependency) requested() string {
+	switch {
+	case d.Version != "":
+		return fmt.Sprintf("version %s", d.Version)
+	case d.Ref != "":
+		return fmt.Sprintf("ref %s", d.Ref)
+	case d.Tag != "":
+		return fmt.Sprintf("tag %s", d.Tag)
+	case d.Branch != "":
+		return fmt.Sprintf("branch %s", d.Branch)
+	case d.Commit != "":
+		return fmt.Sprintf("commit %s", d.Commit)
+	}
+	return ""
+}
+
+// gitRepository splits the location of a git dependency into its repository url, and the path of the subdirectory
+// to use as dependency root, if any. The subdirectory is declared either in the location, after the '//' separator,
+// or by the dependency's path attribute.
+func (d Dependency) gitRepository() (url string, subdir string, err error) {
+	url, _, err = parseGitUrl(d.Location)
+	if err != nil {
+		return "", "", err
+	}
+	url, subdir = splitGitSubdir(url)
+
+	if d.Path != "" {
+		if subdir != "" {
+			return "", "", fmt.Errorf("dependency %s declares conflicting paths: location path, path", d.Name)
+		}
+		subdir = d.Path
+	}
+
+	if subdir != "" {
+		subdir = path.Clean(strings.Trim(subdir, "/"))
+		if subdir == "." {
+			subdir = ""
+		} else if subdir == ".." || strings.HasPrefix(subdir, "../") {
+			return "", "", fmt.Errorf("path of dependency %s is outside its git repository: %s", d.Name, subdir)
+		}
+	}
+
+	return url, subdir, nil
+}
+
+// splitGitSubdir splits a git url on the '//' subdirectory separator, e.g. 'https://example.com/repo.git//policies'.
+// The separator is searched for after the url's scheme, if any.
+func splitGitSubdir(url string) (string, string) {
+	start := 0
+	if i := strings.Index(url, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	if i := strings.Index(url[start:], "//"); i >= 0 {
+		return url[:start+i], url[start+i+len("//"):]
+	}
+	return url, ""
+}
+
+// gitLocation formats the location of a git dependency on the subdirectory subdir of the repository at url,
+// pinned to ref, if any.
+func gitLocation(url, subdir, ref string) string {
+	location := "git+" + url
+	if subdir != "" {
+		location += "//" + subdir
+	}
+	if ref != "" {
+		location += "#" + ref
+	}
+	return location
+}
+
+func parseGitUrl(fullUrl string) (url string, tag string, err error) {
+	trimmedUrl := strings.TrimPrefix(fullUrl, "git+")
+	parts := strings.Split(trimmedUrl, "#")
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("invalid git url %s; only one tag separator '#' allowed", fullUrl)
+	}
+
+	url = parts[0]
+	if len(parts) == 2 {
+		tag = parts[1]
+	}
+	return
+}
+
+// listRemoteRefs lists all references in the remote git repository at url, including the peeled commits of
+// annotated tags.
+func listRemoteRefs(ctx context.Context, url string) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		PeelingOption: git.AppendPeeled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references for git repository %s: %w", url, err)
+	}
+	return refs, nil
+}
+
+// listGitRefs lists all references in the remote git repository at url, or, if offline, in its cached repository.
+func listGitRefs(ctx context.Context, url string, offline bool) ([]*plumbing.Reference, error) {
+	if offline {
+		return cachedGitRefs(ctx, url)
+	}
+	return listRemoteRefs(ctx, url)
+}
+
+// listGitTags lists the names of all tags in the remote git repository at url, or, if offline, in its cached repository.
+func listGitTags(ctx context.Context, url string, offline bool) ([]string, error) {
+	refs, err := listGitRefs(ctx, url, offline)
+	if err != nil {
+		return nil, err
+	}
+	return remoteTags(refs), nil
+}
+
+func remoteTags(refs []*plumbing.Reference) []string {
+	var tags []string
+	for _, ref := range refs {
+		if ref.Name().IsTag() && !strings.HasSuffix(ref.Name().String(), peeledSuffix) {
+			tags = append(tags, ref.Name().Short())
+		}
+	}
+	return tags
+}
+
+func findRef(refs []*plumbing.Reference, name plumbing.ReferenceName) *plumbing.Reference {
+	for _, r := range refs {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// remoteRevision returns the commit ref points to in a remote git repository, as listed by refs.
+// The zero hash is returned if the commit can't be determined from the listing, e.g. for an abbreviated commit SHA.
+func remoteRevision(refs []*plumbing.Reference, ref gitRef) plumbing.Hash {
+	var name plumbing.ReferenceName
+	switch ref.typ {
+	case RefTypeCommit:
+		if len(ref.name) == len(plumbing.ZeroHash.String()) {
+			return plumbing.NewHash(ref.name)
+		}
+		return plumbing.ZeroHash
+	case RefTypeTag:
+		name = plumbing.NewTagReferenceName(ref.name)
+	case RefTypeBranch:
+		name = plumbing.NewBranchReferenceName(ref.name)
+	default:
+		name = plumbing.HEAD
+	}
+
+	// Annotated tags point to a tag object; the commit it points to is listed separately
+	if r := findRef(refs, name+peeledSuffix); r != nil {
+		return r.Hash()
+	}
+	r := findRef(refs, name)
+	if r != nil && r.Type() == plumbing.SymbolicReference {
+		r = findRef(refs, r.Target())
+	}
+	if r == nil {
+		return plumbing.ZeroHash
+	}
+	return r.Hash()
+}
+
+// resolveGitRef resolves the type of a declared ref against the references listed for the remote git repository at url.
+// Commits are not verified, as they can't be listed; they are resolved once the repository has been fetched.
+func resolveGitRef(url string, refs []*plumbing.Reference, ref gitRef) (gitRef, error) {
+	if ref.typ == RefTypeCommit {
+		return ref, nil
+	}
+
+	hasRef := func(name plumbing.ReferenceName) bool {
+		return findRef(refs, name) != nil
+	}
+
+	if (ref.typ == "" || ref.typ == RefTypeTag) && hasRef(plumbing.NewTagReferenceName(ref.name)) {
+		return gitRef{typ: RefTypeTag, name: ref.name}, nil
+	}
+	if (ref.typ == "" || ref.typ == RefTypeBranch) && hasRef(plumbing.NewBranchReferenceName(ref.name)) {
+		return gitRef{typ: RefTypeBranch, name: ref.name}, nil
+	}
+	if ref.typ == "" && commitPattern.MatchString(ref.name) {
+		return gitRef{typ: RefTypeCommit, name: ref.name}, nil
+	}
+
+	if ref.typ == "" {
+		return gitRef{}, fmt.Errorf("no tag, branch, or commit '%s' found in git repository %s", ref.name, url)
+	}
+	return gitRef{}, fmt.Errorf("no %s found in git repository %s", ref, url)
+}
+
+// gitSource is a git dependency resolved to a revision.
+type gitSource struct {
+	url    string
+	subdir string
+	ref    gitRef
+	// hash is the resolved commit; zero if it can't be determined without fetching, e.g. for an abbreviated commit SHA
+	hash plumbing.Hash
+}
+
+// resolveGit resolves the dependency's git repository to a revision. If locked is non-nil, its revision is used instead
+// of resolving the ref declared for the dependency. If offline is set, refs are resolved against the cached repository,
+// rather than the remote one.
+func (d Dependency) resolveGit(ctx context.Context, locked *LockedDependency, offline bool) (gitSource, error) {
+	url, ref, constraint, err := d.gitRef()
+	if err != nil {
+		return gitSource{}, err
+	}
+	_, subdir, err := d.gitRepository()
+	if err != nil {
+		return gitSource{}, err
+	}
+
+	src := gitSource{url: url, subdir: subdir, ref: ref}
+	if locked != nil {
+		printer.From(ctx).Debug("Using locked revision %s", locked.Revision)
+		src.ref = gitRef{typ: locked.RefType, name: locked.Ref}
+		src.hash = plumbing.NewHash(locked.Revision)
+	} else if ref.typ == RefTypeCommit {
+		src.hash = remoteRevision(nil, ref)
+	} else {
+		refs, err := listGitRefs(ctx, url, offline)
+		if err != nil {
+			return gitSource{}, err
+		}
+		if constraint != "" {
+			tag, err := selectVersion(constraint, remoteTags(refs))
+			if err != nil {
+				return gitSource{}, fmt.Errorf("failed to resolve version of dependency %s: %w", d.Name, err)
+			}
+			printer.From(ctx).Info("Resolved version '%s' of dependency %s to tag '%s'", constraint, d.Name, tag)
+			src.ref = gitRef{typ: RefTypeTag, name: tag}
+		} else if ref.name != "" {
+			if src.ref, err = resolveGitRef(url, refs, ref); err != nil {
+				return gitSource{}, fmt.Errorf("failed to resolve git ref of dependency %s: %w", d.Name, err)
+			}
+		} else {
+			printer.From(ctx).Debug("No ref specified, using HEAD")
+		}
+		src.hash = remoteRevision(refs, src.ref)
+	}
+
+	return src, nil
+}
+
+// fetchTree returns the cache directory holding the source tree of the resolved revision, and the revision's commit
+// SHA. Only the subdirectory of the repository is included in the source tree, if declared.
+// The remote repository is only fetched from if the revision isn't already cached. If offline is set, it's never
+// contacted.
+func (s gitSource) fetchTree(ctx context.Context, offline bool) (treeDir string, hash plumbing.Hash, err error) {
+	if !s.hash.IsZero() {
+		treeDir, err := cachedTreeDir(s.hash, s.subdir)
+		if err != nil {
+			return "", plumbing.ZeroHash, err
+		}
+		if utils.IsDir(treeDir) {
+			printer.From(ctx).Debug("Using cached source tree of revision %s", s.hash)
+			return treeDir, s.hash, nil
+		}
+	}
+
+	repo, hash, err := fetchGitRevision(ctx, s.url, s.ref, s.hash, offline)
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return "", plumbing.ZeroHash, fmt.Errorf("failed to checkout revision '%s' for git repository %s: %w", hash, s.url, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", plumbing.ZeroHash, fmt.Errorf("failed to checkout revision '%s' for git repository %s: %w", hash, s.url, err)
+	}
+	if s.subdir != "" {
+		if tree, err = tree.Tree(s.subdir); err != nil {
+			return "", plumbing.ZeroHash, fmt.Errorf("path '%s' not found in git repository %s at revision %s: %w",
+				s.subdir, s.url, hash, err)
+		}
+	}
+
+	if treeDir, err = cacheGitTree(ctx, tree, hash, s.subdir); err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	return treeDir, hash, nil
+}
+
+// fetchGitRevision fetches ref from the git repository at url, and returns a repository containing the commit it
+// resolves to. If hash is non-zero, it's the commit expected for ref.
+// Tags, branches, and full commit SHAs are cloned shallowly, as only a single commit is needed. Other refs, tags and
+// branches that have moved since hash was resolved, and commits the remote doesn't allow to be fetched on their own,
+// are fetched into the cached repository for url; which is only fetched from if it doesn't already contain the commit.
+// If offline is set, only the cached repository is used.
+func fetchGitRevision(ctx context.Context, url string, ref gitRef, hash plumbing.Hash, offline bool) (*git.Repository, plumbing.Hash, error) {
+	cloneRef := ref
+	if ref.typ == RefTypeCommit && !hash.IsZero() {
+		// The ref may be an abbreviated SHA of the commit
+		cloneRef.name = hash.String()
+	}
+	// Commits never move, so one already in the cached repository is never fetched again
+	if gitCloneDepth(cloneRef) > 0 && !offline && !(ref.typ == RefTypeCommit && hasCachedGitCommit(ctx, url, hash)) {
+		repo, err := cloneGitRepository(ctx, url, cloneRef)
+		if errors.Is(err, git.ErrExactSHA1NotSupported) {
+			printer.From(ctx).Debug("Git repository %s doesn't allow fetching commit %s on its own, fetching full history", url, cloneRef.name)
+		} else if err != nil {
+			return nil, plumbing.ZeroHash, err
+		} else {
+			h, err := repo.ResolveRevision(cloneRef.revision())
+			if err != nil {
+				return nil, plumbing.ZeroHash, fmt.Errorf("failed to resolve %s in git repository %s: %w", ref, url, err)
+			}
+			if hash.IsZero() || *h == hash {
+				return repo, *h, nil
+			}
+			printer.From(ctx).Debug("Revision %s not found at %s, fetching full history", hash, ref)
+		}
+	}
+
+	unlock := lockCachedGitRepository(url)
+	defer unlock()
+
+	repo, err := cachedGitRepository(ctx, url, !offline)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	resolve := func() (plumbing.Hash, error) {
+		if !hash.IsZero() {
+			if _, err := repo.CommitObject(hash); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("revision '%s' not found in git repository %s: %w", hash, url, err)
+			}
+			return hash, nil
+		}
+		if ref.name == "" {
+			return plumbing.ZeroHash, fmt.Errorf("failed to resolve HEAD for git repository %s", url)
+		}
+		h, err := repo.ResolveRevision(ref.revision())
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to resolve %s in git repository %s: %w", ref, url, err)
+		}
+		return *h, nil
+	}
+
+	if h, err := resolve(); err == nil {
+		printer.From(ctx).Debug("Using cached git repository for %s", url)
+		return repo, h, nil
+	}
+
+	if offline {
+		switch {
+		case !hash.IsZero():
+			return nil, plumbing.ZeroHash, fmt.Errorf("revision %s of git repository %s %w", hash, url, errNotCached)
+		case ref.name == "":
+			return nil, plumbing.ZeroHash, fmt.Errorf("HEAD of git repository %s %w", url, errNotCached)
+		default:
+			return nil, plumbing.ZeroHash, fmt.Errorf("%s of git repository %s %w", ref, url, errNotCached)
+		}
+	}
+
+	if err := fetchCachedGitRepository(ctx, url, repo); err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	h, err := resolve()
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return repo, h, nil
+}
+
+// gitCloneDepth returns the depth at which to clone a repository for ref.
+// Tags and branches are fetched shallowly, as only their tip is needed; as are commits declared by their full SHA.
+// Abbreviated commit SHAs can only be found in the full history.
+func gitCloneDepth(ref gitRef) int {
+	switch ref.typ {
+	case RefTypeTag, RefTypeBranch:
+		return 1
+	case RefTypeCommit:
+		if plumbing.IsHash(ref.name) {
+			return 1
+		}
+		return 0
+	default:
+		return 0
+	}
+}
+
+// cloneGitRepository clones the git repository at url into memory. If ref is a tag, branch, or full commit SHA, only
+// that ref is fetched, without history. Fetching a single commit fails with git.ErrExactSHA1NotSupported if the remote
+// doesn't allow it.
+func cloneGitRepository(ctx context.Context, url string, ref gitRef) (*git.Repository, error) {
+	if ref.typ == RefTypeCommit && gitCloneDepth(ref) > 0 {
+		return fetchGitCommit(ctx, url, plumbing.NewHash(ref.name))
+	}
+
+	opts := &git.CloneOptions{
+		URL:      url,
+		Progress: printer.From(ctx).DebugPrinter(),
+	}
+	if depth := gitCloneDepth(ref); depth > 0 {
+		opts.Depth = depth
+		opts.SingleBranch = true
+		if ref.typ == RefTypeTag {
+			opts.ReferenceName = plumbing.NewTagReferenceName(ref.name)
+		} else {
+			opts.ReferenceName = plumbing.NewBranchReferenceName(ref.name)
+		}
+	}
+
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone git repository %s: %w", url, err)
+	}
+	return repo, nil
+}
+
+// fetchGitCommit fetches the single commit hash, without history, from the git repository at url into memory.
+func fetchGitCommit(ctx context.Context, url string, hash plumbing.Hash) (*git.Repository, error) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone git repository %s: %w", url, err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}}); err != nil {
+		return nil, fmt.Errorf("failed to clone git repository %s: %w", url, err)
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:refs/commits/%s", hash, hash))},
+		Depth:      1,
+		Progress:   printer.From(ctx).DebugPrinter(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone git repository %s: %w", url, err)
+	}
+	return repo, nil
+}
+
+// writeGitTree writes all files in tree, including subdirectories, into targetDir.
+// Symlinks are only kept if they resolve to a file within the tree; as links out of it would have files elsewhere on
+// the host copied into the dependency.
+func writeGitTree(ctx context.Context, tree *object.Tree, targetDir string) error {
+	var links []string
+	err := tree.Files().ForEach(func(f *object.File) error {
+		target := filepath.Join(targetDir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if f.Mode == filemode.Symlink {
+			link, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			links = append(links, f.Name)
+			return os.Symlink(link, target)
+		}
+
+		perm := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			perm = 0755
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		w, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		_, err = io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Links are resolved once the whole tree is written, as they may point through other links; and until no more are
+	// removed, as removing a link breaks the links pointing through it
+	root, err := filepath.EvalSymlinks(targetDir)
+	if err != nil {
+		return err
+	}
+	for removed := true; removed; {
+		removed = false
+		kept := links[:0]
+		for _, name := range links {
+			link := filepath.Join(targetDir, filepath.FromSlash(name))
+			if resolved, err := filepath.EvalSymlinks(link); err == nil {
+				if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." &&
+					!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+					kept = append(kept, name)
+					continue
+				}
+			}
+			printer.From(ctx).Warn("Skipping symlink %s, which doesn't resolve to a file within the git repository", name)
+			if err := os.Remove(link); err != nil {
+				return err
+			}
+			removed = true
+		}
+		links = kept
+	}
+	return nil
+}
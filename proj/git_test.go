@@ -0,0 +1,477 @@
+package proj
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateResolvesGitRefs(t *testing.T) {
+	workDir := t.TempDir()
+	repo := initGitRepo(t, workDir)
+	commits := map[string]string{}
+	commit := func(label string) string {
+		commits[label] = commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\ncommit := \"%s\"\n", label))
+		return commits[label]
+	}
+
+	tagCommit(t, repo, "v1", commit("c1"))
+	tagCommit(t, repo, "same", commits["c1"])
+	annotatedTagCommit(t, repo, "v2", commit("c2"))
+	checkoutBranch(t, repo, "feature", true)
+	commit("c3")
+	checkoutBranch(t, repo, "same", true)
+	checkoutBranch(t, repo, "master", false)
+	commit("c4")
+
+	bareDir := t.TempDir()
+	pushToBareRepo(t, repo, bareDir)
+	location := fmt.Sprintf("git+file://%s", bareDir)
+
+	tests := []struct {
+		note            string
+		dependency      string
+		expectedCommit  string
+		expectedRef     string
+		expectedRefType string
+		expectedErr     string
+	}{
+		{
+			note:           "HEAD",
+			dependency:     fmt.Sprintf("location: %s", location),
+			expectedCommit: "c4",
+		},
+		{
+			note:            "tag",
+			dependency:      fmt.Sprintf("location: %s#v1", location),
+			expectedCommit:  "c1",
+			expectedRef:     "v1",
+			expectedRefType: RefTypeTag,
+		},
+		{
+			note:            "annotated tag",
+			dependency:      fmt.Sprintf("location: %s#v2", location),
+			expectedCommit:  "c2",
+			expectedRef:     "v2",
+			expectedRefType: RefTypeTag,
+		},
+		{
+			note:            "branch",
+			dependency:      fmt.Sprintf("location: %s#feature", location),
+			expectedCommit:  "c3",
+			expectedRef:     "feature",
+			expectedRefType: RefTypeBranch,
+		},
+		{
+			note:            "full commit",
+			dependency:      fmt.Sprintf("location: %s#%s", location, commits["c2"]),
+			expectedCommit:  "c2",
+			expectedRef:     commits["c2"],
+			expectedRefType: RefTypeCommit,
+		},
+		{
+			note:            "abbreviated commit",
+			dependency:      fmt.Sprintf("location: %s#%s", location, commits["c1"][:7]),
+			expectedCommit:  "c1",
+			expectedRef:     commits["c1"][:7],
+			expectedRefType: RefTypeCommit,
+		},
+		{
+			note:            "ambiguous ref resolved as tag",
+			dependency:      fmt.Sprintf("location: %s#same", location),
+			expectedCommit:  "c1",
+			expectedRef:     "same",
+			expectedRefType: RefTypeTag,
+		},
+		{
+			note:            "ref attribute",
+			dependency:      fmt.Sprintf("location: %s\n    ref: feature", location),
+			expectedCommit:  "c3",
+			expectedRef:     "feature",
+			expectedRefType: RefTypeBranch,
+		},
+		{
+			note:            "branch attribute",
+			dependency:      fmt.Sprintf("location: %s\n    branch: same", location),
+			expectedCommit:  "c3",
+			expectedRef:     "same",
+			expectedRefType: RefTypeBranch,
+		},
+		{
+			note:            "commit attribute",
+			dependency:      fmt.Sprintf("location: %s\n    commit: %s", location, commits["c2"][:10]),
+			expectedCommit:  "c2",
+			expectedRef:     commits["c2"][:10],
+			expectedRefType: RefTypeCommit,
+		},
+		{
+			note:        "unknown tag",
+			dependency:  fmt.Sprintf("location: %s\n    tag: feature", location),
+			expectedErr: "no tag 'feature' found in git repository",
+		},
+		{
+			note:        "unknown ref",
+			dependency:  fmt.Sprintf("location: %s#nope", location),
+			expectedErr: "no tag, branch, or commit 'nope' found in git repository",
+		},
+		{
+			note:        "unknown commit",
+			dependency:  fmt.Sprintf("location: %s\n    commit: 0123456abc", location),
+			expectedErr: "failed to resolve commit '0123456abc' in git repository",
+		},
+		{
+			note:        "numeric commit",
+			dependency:  fmt.Sprintf("location: %s\n    commit: 0123456789", location),
+			expectedErr: "failed to resolve commit '0123456789' in git repository",
+		},
+		{
+			note:        "conflicting refs",
+			dependency:  fmt.Sprintf("location: %s\n    branch: feature\n    commit: %s", location, commits["c1"]),
+			expectedErr: "dependency lib declares conflicting git refs: branch, commit",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": fmt.Sprintf("dependencies:\n  lib:\n    %s\n    namespace: false\n", tc.dependency),
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				lock, err := ReadLockFromFile(project.LockFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(lock.Dependencies) != 1 {
+					t.Fatalf("expected exactly one locked dependency, got %v", lock.Dependencies)
+				}
+				locked := lock.Dependencies[0]
+				if locked.Revision != commits[tc.expectedCommit] {
+					t.Fatalf("expected revision %s (%s), got %s", commits[tc.expectedCommit], tc.expectedCommit, locked.Revision)
+				}
+				if locked.Ref != tc.expectedRef || locked.RefType != tc.expectedRefType {
+					t.Fatalf("expected ref %s '%s', got %s '%s'", tc.expectedRefType, tc.expectedRef, locked.RefType, locked.Ref)
+				}
+
+				dep := project.Dependencies["lib"]
+				expectFileContent(t, filepath.Join(dep.dir(dependenciesDir(path)), "policy.rego"),
+					fmt.Sprintf("package lib\n\ncommit := \"%s\"\n", tc.expectedCommit))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateGitSubdirectory(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	commitFile(t, repo, "other/policy.rego", "package other\n")
+	commitFile(t, repo, "policies/lib/policy.rego", "package lib\n")
+	tagCommit(t, repo, "v1", commitFile(t, repo, "policies/lib/nested/policy.rego", "package lib.nested\n"))
+	location := fmt.Sprintf("git+file://%s", repoDir)
+
+	tests := []struct {
+		note        string
+		dependency  string
+		expectedErr string
+	}{
+		{
+			note:       "path attribute",
+			dependency: fmt.Sprintf("location: %s#v1\n    path: policies/lib", location),
+		},
+		{
+			note:       "location path",
+			dependency: fmt.Sprintf("location: %s//policies/lib#v1", location),
+		},
+		{
+			note:       "location path without ref",
+			dependency: fmt.Sprintf("location: %s//policies/lib/", location),
+		},
+		{
+			note:        "conflicting paths",
+			dependency:  fmt.Sprintf("location: %s//policies\n    path: policies/lib", location),
+			expectedErr: "dependency lib declares conflicting paths",
+		},
+		{
+			note:        "unknown path",
+			dependency:  fmt.Sprintf("location: %s#v1\n    path: policies/nope", location),
+			expectedErr: "path 'policies/nope' not found in git repository",
+		},
+		{
+			note:        "path outside repository",
+			dependency:  fmt.Sprintf("location: %s\n    path: policies/../../lib", location),
+			expectedErr: "path of dependency lib is outside its git repository",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": fmt.Sprintf("dependencies:\n  lib:\n    %s\n    namespace: false\n", tc.dependency),
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				depDir := project.Dependencies["lib"].dir(dependenciesDir(path))
+				expectFileContent(t, filepath.Join(depDir, "policy.rego"), "package lib\n")
+				expectFileContent(t, filepath.Join(depDir, "nested", "policy.rego"), "package lib.nested\n")
+				for _, unexpected := range []string{"other", "policies", ".git"} {
+					if _, err := os.Stat(filepath.Join(depDir, unexpected)); !os.IsNotExist(err) {
+						t.Fatalf("expected %s to be excluded from dependency", unexpected)
+					}
+				}
+
+				if err := project.Load(); err != nil {
+					t.Fatal(err)
+				}
+				locations, err := project.DataLocations()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(locations) != 2 || locations[1] != depDir {
+					t.Fatalf("expected dependency data location %s, got %v", depDir, locations)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateGitDependencySymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.rego")
+	if err := os.WriteFile(outside, []byte("package secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	for name, target := range map[string]string{
+		"inside.rego":   "policy.rego",
+		"absolute.rego": outside,
+		"relative.rego": "../secret.rego",
+		// Points to a link that is removed
+		"chain.rego": "relative.rego",
+	} {
+		if err := os.Symlink(target, filepath.Join(repoDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddGlob("*.rego"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "policy.rego", "package lib\n")
+
+	files := map[string]string{
+		"opa.project": fmt.Sprintf("dependencies:\n  lib:\n    location: git+file://%s\n    namespace: false\n", repoDir),
+	}
+	err = withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		depDir := project.Dependencies["lib"].dir(dependenciesDir(path))
+		expectFileContent(t, filepath.Join(depDir, "inside.rego"), "package lib\n")
+		for _, name := range []string{"absolute.rego", "relative.rego", "chain.rego"} {
+			if _, err := os.Lstat(filepath.Join(depDir, name)); !os.IsNotExist(err) {
+				t.Fatalf("expected symlink %s to be skipped", name)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloneGitRepositoryDepth(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	first := commitFile(t, repo, "policy.rego", "package lib\n\nx := 1\n")
+	tagCommit(t, repo, "v1", commitFile(t, repo, "policy.rego", "package lib\n\nx := 2\n"))
+	url := fmt.Sprintf("file://%s", repoDir)
+
+	// Allows single commits to be fetched
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		note            string
+		ref             gitRef
+		expectedShallow bool
+	}{
+		{note: "HEAD", ref: gitRef{}, expectedShallow: false},
+		{note: "tag", ref: gitRef{typ: RefTypeTag, name: "v1"}, expectedShallow: true},
+		{note: "branch", ref: gitRef{typ: RefTypeBranch, name: "master"}, expectedShallow: true},
+		{note: "commit", ref: gitRef{typ: RefTypeCommit, name: first}, expectedShallow: true},
+		{note: "abbreviated commit", ref: gitRef{typ: RefTypeCommit, name: "0123abc"}, expectedShallow: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			repo, err := cloneGitRepository(context.Background(), url, tc.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shallow, err := repo.Storer.Shallow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := len(shallow) > 0; actual != tc.expectedShallow {
+				t.Fatalf("expected shallow clone: %v, got: %v (%v)", tc.expectedShallow, actual, shallow)
+			}
+			if tc.ref.typ == RefTypeCommit && tc.expectedShallow {
+				if _, err := repo.CommitObject(plumbing.NewHash(first)); err != nil {
+					t.Fatalf("expected commit %s to be fetched: %v", first, err)
+				}
+			}
+		})
+	}
+
+	t.Run("commit not allowed", func(t *testing.T) {
+		cfg.Raw.RemoveSection("uploadpack")
+		if err := repo.SetConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cloneGitRepository(context.Background(), url, gitRef{typ: RefTypeCommit, name: first}); !errors.Is(err, git.ErrExactSHA1NotSupported) {
+			t.Fatalf("expected commit to not be allowed, got %v", err)
+		}
+	})
+}
+
+func initGitRepo(t *testing.T, dir string) *git.Repository {
+	t.Helper()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func commitFile(t *testing.T, repo *git.Repository, path, content string) string {
+	t.Helper()
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fullPath := filepath.Join(w.Filesystem.Root(), path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := w.Commit(fmt.Sprintf("update %s", path), &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
+
+func tagCommit(t *testing.T, repo *git.Repository, tag, revision string) {
+	t.Helper()
+	if _, err := repo.CreateTag(tag, plumbing.NewHash(revision), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func annotatedTagCommit(t *testing.T, repo *git.Repository, tag, revision string) {
+	t.Helper()
+	if _, err := repo.CreateTag(tag, plumbing.NewHash(revision), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		Message: tag,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkoutBranch(t *testing.T, repo *git.Repository, branch string, create bool) {
+	t.Helper()
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Create: create,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pushToBareRepo pushes all branches and tags of repo to a new bare repository in dir.
+func pushToBareRepo(t *testing.T, repo *git.Repository, dir string) {
+	t.Helper()
+	if _, err := git.PlainInit(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,318 @@
+package proj
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"github.com/open-policy-agent/opa/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	importsDir = "imports"
+	// compiledProjectDir holds the compiled copies of source and test dirs within the project dir, at their paths
+	// relative to it; and compiledExternalDir those of dirs outside it
+	compiledProjectDir  = "project"
+	compiledExternalDir = "external"
+)
+
+// alias maps references to data rooted at from, to be rooted at to.
+type alias struct {
+	name string
+	from ast.Ref
+	to   ast.Ref
+}
+
+// parseImports returns the aliases declared by imports, mapping logical names to namespaces; longest names first, so
+// that the most specific alias of a reference is applied.
+func parseImports(imports map[string]string) ([]alias, error) {
+	aliases := make([]alias, 0, len(imports))
+	for name, namespace := range imports {
+		from, err := parseDataPath(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid import %s: %w", name, err)
+		}
+		to, err := parseDataPath(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace %s of import %s: %w", namespace, name, err)
+		}
+		aliases = append(aliases, alias{name: name, from: from, to: to})
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		if len(aliases[i].from) != len(aliases[j].from) {
+			return len(aliases[i].from) > len(aliases[j].from)
+		}
+		return aliases[i].name < aliases[j].name
+	})
+	return aliases, nil
+}
+
+// parseDataPath parses a dot-separated path, such as a namespace, into a reference rooted at data.
+func parseDataPath(path string) (ast.Ref, error) {
+	ref, err := ast.ParseRef(fmt.Sprintf("data.%s", path))
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range ref[1:] {
+		if _, ok := term.Value.(ast.String); !ok {
+			return nil, fmt.Errorf("expected a dot-separated path")
+		}
+	}
+	return ref, nil
+}
+
+// importModules rewrites the references to data in the Rego modules found in dirs, that are rooted at the name of
+// any of aliases, to be rooted at its namespace. Modules declaring packages under, or above, any of the names fail.
+// Modules without any aliased references are left untouched; in others, only the references are changed.
+func importModules(ctx context.Context, root string, dirs []string, aliases []alias) error {
+	files, err := regoFiles(dirs, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			name = file
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		imported, err := importModule(name, data, aliases)
+		if err != nil {
+			return err
+		}
+		if imported == nil {
+			continue
+		}
+
+		if err := os.WriteFile(file, imported, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// importModule returns the module in data, named name, with its aliased references rewritten; or nil if the module
+// has no statements, or no aliased references. References are rewritten in place, keeping the layout of the module,
+// so that errors in the rewritten module are reported at the same rows as in the original.
+func importModule(name string, data []byte, aliases []alias) ([]byte, error) {
+	module, err := ast.ParseModule(name, string(data))
+	if err != nil {
+		if stmts, _, stmtsErr := ast.ParseStatements(name, string(data)); stmtsErr == nil && len(stmts) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to parse module: %w", err)
+	}
+
+	pkg := module.Package.Path
+	for _, a := range aliases {
+		if pkg.HasPrefix(a.from) || a.from.HasPrefix(pkg) {
+			return nil, fmt.Errorf("package %s of module %s is shadowed by import %s", pkg, name, a.name)
+		}
+	}
+
+	var edits []textEdit
+
+	// Imports keep the name they're referred to by, if the last segment of their path is rewritten
+	for _, imp := range module.Imports {
+		if ref, ok := imp.Path.Value.(ast.Ref); ok && len(imp.Alias) == 0 {
+			if rewritten := applyAliases(ref, aliases); !rewritten.Equal(ref) && !rewritten[len(rewritten)-1].Equal(ref[len(ref)-1]) {
+				end := imp.Path.Location.Offset + len(imp.Path.Location.Text)
+				edits = append(edits, textEdit{start: end, end: end, text: fmt.Sprintf(" as %s", imp.Name())})
+			}
+		}
+	}
+
+	ast.WalkRefs(module, func(ref ast.Ref) bool {
+		for _, a := range aliases {
+			if !ref.HasPrefix(a.from) {
+				continue
+			}
+			// The aliased prefix of the reference, up to and including its last segment; and the closing bracket of
+			// the segment, if written in brackets
+			first, last := ref[0].Location, ref[len(a.from)-1].Location
+			if first == nil || last == nil {
+				break
+			}
+			start, end := first.Offset, last.Offset+len(last.Text)
+			if bytes.HasPrefix(last.Text, []byte(`"`)) || bytes.HasPrefix(last.Text, []byte("`")) {
+				end += bytes.IndexByte(data[end:], ']') + 1
+			}
+			edits = append(edits, textEdit{start: start, end: end, text: a.to.String()})
+			break
+		}
+		return false
+	})
+	if len(edits) == 0 {
+		return nil, nil
+	}
+
+	// Edits are applied back to front, so that the offsets of the remaining edits stay valid
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	imported := append([]byte(nil), data...)
+	for _, e := range edits {
+		imported = append(imported[:e.start], append([]byte(e.text), imported[e.end:]...)...)
+	}
+	return imported, nil
+}
+
+// textEdit replaces the bytes from start to end, exclusive, with text.
+type textEdit struct {
+	start int
+	end   int
+	text  string
+}
+
+// applyAliases returns ref rooted at the namespace of the first of aliases it's rooted at the name of; or ref, if none.
+func applyAliases(ref ast.Ref, aliases []alias) ast.Ref {
+	for _, a := range aliases {
+		if ref.HasPrefix(a.from) {
+			return a.to.Concat(ref[len(a.from):])
+		}
+	}
+	return ref
+}
+
+// CompileSources writes copies of the loaded project's source and test dirs to the project's .opa directory, with
+// the references to the names declared in the project's imports rewritten to the namespaces they're mapped to; and
+// makes DataLocations and TestLocations return the copies in place of the dirs. Projects without imports are left as
+// they are.
+func (p *Project) CompileSources() error {
+	rootDir := filepath.Dir(p.filePath)
+	compiledDir := filepath.Join(rootDir, dotOpaDir, importsDir)
+	if err := os.RemoveAll(compiledDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", compiledDir, err)
+	}
+	p.compiled = nil
+	if len(p.Imports) == 0 {
+		return nil
+	}
+
+	aliases, err := parseImports(p.Imports)
+	if err != nil {
+		return err
+	}
+
+	sources, err := p.sourceLocations()
+	if err != nil {
+		return err
+	}
+	tests, err := p.TestLocations(false)
+	if err != nil {
+		return err
+	}
+
+	printer.Debug("Compiling project sources against imports, in %s", compiledDir)
+	locations := utils.FilterExistingFiles(append(sources, tests...))
+	compiled := map[string]string{}
+	nested := map[string]string{}
+	for i, location := range locations {
+		if _, ok := compiled[location]; ok {
+			continue
+		}
+		// A location within another is already copied with it, and is compiled to its place within the copy; as
+		// copying it again would load its modules twice
+		if parent := enclosingLocation(location, locations); parent != "" {
+			nested[location] = parent
+			continue
+		}
+		// Copies mirror the project's layout, so that they keep their names as the project's source dirs change
+		root := filepath.Join(compiledDir, compiledProjectDir)
+		rel, err := filepath.Rel(rootDir, location)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			root = filepath.Join(compiledDir, compiledExternalDir, strconv.Itoa(i))
+			rel = filepath.Base(location)
+		}
+		dst := filepath.Join(root, rel)
+		dstDir := dst
+		if info, err := os.Stat(location); err == nil && !info.IsDir() {
+			dstDir = filepath.Dir(dst)
+		}
+		if err := utils.CopyAll(context.Background(), location, dstDir, []string{dotOpaDir, ".git"}); err != nil {
+			return err
+		}
+		if err := importModules(context.Background(), root, []string{dst}, aliases); err != nil {
+			name, relErr := filepath.Rel(rootDir, location)
+			if relErr != nil {
+				name = location
+			}
+			return fmt.Errorf("failed to compile project source %s: %w", name, err)
+		}
+		compiled[location] = dst
+	}
+	for location, parent := range nested {
+		rel, err := filepath.Rel(parent, location)
+		if err != nil {
+			return err
+		}
+		compiled[location] = filepath.Join(compiled[parent], rel)
+	}
+	p.compiled = compiled
+	return nil
+}
+
+// SourcePaths returns output, such as the output of OPA, with the paths of the copies written by CompileSources
+// replaced by the paths they're copied from; as the copies keep the layout of their modules, positions in the copies
+// are at the same rows in the originals.
+func (p *Project) SourcePaths(output string) string {
+	dsts := make([]string, 0, len(p.compiled))
+	locations := make(map[string]string, len(p.compiled))
+	for location, dst := range p.compiled {
+		dsts = append(dsts, dst)
+		locations[dst] = location
+	}
+	// Longest first, so that a copy isn't mistaken for another one its path starts with
+	sort.Slice(dsts, func(i, j int) bool {
+		return len(dsts[i]) > len(dsts[j])
+	})
+	for _, dst := range dsts {
+		output = strings.ReplaceAll(output, dst, locations[dst])
+	}
+	return output
+}
+
+// enclosingLocation returns the outermost of locations that location is within, other than location itself; or "" if
+// none.
+func enclosingLocation(location string, locations []string) string {
+	var enclosing string
+	for _, other := range locations {
+		rel, err := filepath.Rel(other, location)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if enclosing == "" || len(other) < len(enclosing) {
+			enclosing = other
+		}
+	}
+	return enclosing
+}
+
+// compiledLocations returns locations, with those compiled by CompileSources replaced by their copies.
+func (p *Project) compiledLocations(locations []string) []string {
+	if p.compiled == nil {
+		return locations
+	}
+	result := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if compiled, ok := p.compiled[location]; ok {
+			location = compiled
+		}
+		result = append(result, location)
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package proj
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportModule(t *testing.T) {
+	imports := map[string]string{
+		"authz":       "utils.authz",
+		"authz.roles": "rbac",
+		"lists":       "sl",
+	}
+
+	tests := []struct {
+		note        string
+		module      string
+		expected    string
+		expectedErr string
+	}{
+		{
+			note: "references and imports",
+			module: `package main
+
+import data.authz
+import data.lists.admins
+
+allow {
+	authz.allow
+	data.authz.roles.admin[_] == input.user
+	admins[_] == input.user
+	data.other.x
+}
+`,
+			expected: `package main
+
+import data.utils.authz
+import data.sl.admins
+
+allow {
+	authz.allow
+	data.rbac.admin[_] == input.user
+	admins[_] == input.user
+	data.other.x
+}
+`,
+		},
+		{
+			note: "import keeps its name",
+			module: `package main
+
+import data.lists
+
+p := lists.x
+`,
+			expected: `package main
+
+import data.sl as lists
+
+p := lists.x
+`,
+		},
+		{
+			note:     "layout is kept",
+			module:   "package main\n\n# Comment\nimport data.lists\n\np { data[ \"authz\" ].roles.x == 1;   input.q[data.lists.y] }\nq := data[\"authz\"].allow\n",
+			expected: "package main\n\n# Comment\nimport data.sl as lists\n\np { data.rbac.x == 1;   input.q[data.sl.y] }\nq := data.utils.authz.allow\n",
+		},
+		{
+			note:   "no aliased references",
+			module: "package main\n\np := data.other.x\n",
+		},
+		{
+			note:   "no statements",
+			module: "# Only comments\n",
+		},
+		{
+			note:        "shadowed package",
+			module:      "package authz.x\n\np := data.authz.y\n",
+			expectedErr: "package data.authz.x of module policy.rego is shadowed by import authz",
+		},
+	}
+
+	aliases, err := parseImports(imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			actual, err := importModule("policy.rego", []byte(tc.module), aliases)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expected == "" {
+				if actual != nil {
+					t.Fatalf("expected module to be left as is, got:\n%s", actual)
+				}
+				return
+			}
+			if string(actual) != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseImports(t *testing.T) {
+	tests := []struct {
+		imports     map[string]string
+		expectedErr string
+	}{
+		{
+			imports: map[string]string{"a.b": "c"},
+		},
+		{
+			imports:     map[string]string{"a[x]": "c"},
+			expectedErr: "invalid import a[x]: expected a dot-separated path",
+		},
+		{
+			imports:     map[string]string{"a": ""},
+			expectedErr: "invalid namespace  of import a",
+		},
+	}
+
+	for _, tc := range tests {
+		_, err := parseImports(tc.imports)
+		if tc.expectedErr == "" && err != nil {
+			t.Fatalf("%v: unexpected error %v", tc.imports, err)
+		}
+		if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+			t.Fatalf("%v: expected error containing '%s', got %v", tc.imports, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestDependencyImports(t *testing.T) {
+	files := map[string]string{
+		// a refers to the lib package of its dependency b by its own alias
+		"opa.project":   "dependencies:\n  a: file:/a\n",
+		"a/opa.project": "imports:\n  lib: b.lib\ndependencies:\n  b: file:/b\n",
+		"a/policy.rego": "package a\n\np := data.lib.x\n",
+		"b/policy.rego": "package lib\n\nx := true\n",
+	}
+
+	err := withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		a := project.Dependencies["a"]
+		expectFileContent(t, filepath.Join(a.dir(dependenciesDir(path)), "policy.rego"),
+			"package a.a\n\np := data.a.b.lib.x\n")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package proj
+
+import (
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+)
+
+const (
+	lockFileName   = "opa.lock"
+	lockFileHeader = "# This file is generated by odm. Do not edit it manually.\n"
+)
+
+// Lock is the content of an opa.lock file, recording the resolved state of every dependency in a project's
+// dependency tree, including transitive dependencies.
+type Lock struct {
+	Dependencies []LockedDependency `yaml:"dependencies"`
+}
+
+type LockedDependency struct {
+	Name      string `yaml:"name"`
+	Location  string `yaml:"location"`
+	Path      string `yaml:"path,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Requested string `yaml:"requested,omitempty"`
+	Resolved  string `yaml:"resolved,omitempty"`
+	Ref       string `yaml:"ref,omitempty"`
+	RefType   string `yaml:"ref_type,omitempty"`
+	Revision  string `yaml:"revision,omitempty"`
+	Digest    string `yaml:"digest"`
+}
+
+func (ld LockedDependency) id() string {
+	return DepId(ld.Namespace, depSource(ld.Location, ld.Path))
+}
+
+func ReadLockFromFile(path string) (*Lock, error) {
+	if !utils.FileExists(path) {
+		return &Lock{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file %s: %w", path, err)
+	}
+
+	var lock Lock
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", path, err)
+	}
+
+	return &lock, nil
+}
+
+func (l *Lock) WriteToFile(path string) error {
+	printer.Debug("Writing lock file to %s", path)
+
+	l.sort()
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock file %s: %w", path, err)
+	}
+
+	data = append([]byte(lockFileHeader), data...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write lock file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (l *Lock) find(id string) *LockedDependency {
+	if l == nil {
+		return nil
+	}
+	for i := range l.Dependencies {
+		if l.Dependencies[i].id() == id {
+			return &l.Dependencies[i]
+		}
+	}
+	return nil
+}
+
+func (l *Lock) add(dep LockedDependency) {
+	if existing := l.find(dep.id()); existing != nil {
+		*existing = dep
+		return
+	}
+	l.Dependencies = append(l.Dependencies, dep)
+}
+
+func (l *Lock) sort() {
+	sort.Slice(l.Dependencies, func(i, j int) bool {
+		a, b := l.Dependencies[i], l.Dependencies[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Location != b.Location {
+			return a.Location < b.Location
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,230 @@
+package proj
+
+import (
+	"fmt"
+	"github.com/johanfylling/odm/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateReproducesLockedGitRevision(t *testing.T) {
+	// A branch is cloned shallowly, so its locked revision is no longer found at the tip of the branch
+	for note, ref := range map[string]string{"HEAD": "", "branch": "#master"} {
+		t.Run(note, func(t *testing.T) {
+			repoDir := t.TempDir()
+			repo := initGitRepo(t, repoDir)
+			first := commitFile(t, repo, "policy.rego", "package lib\n\nx := 1\n")
+
+			location := fmt.Sprintf("git+file://%s%s", repoDir, ref)
+			files := map[string]string{
+				"opa.project": fmt.Sprintf(`dependencies:
+  lib:
+    location: %s
+    namespace: false
+`, location),
+			}
+			err := withTempFiles(files, func(path string) {
+				update := func(opts UpdateOptions) *Lock {
+					t.Helper()
+					project, err := ReadProjectFromFile(path, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := project.Update(opts); err != nil {
+						t.Fatal(err)
+					}
+					lock, err := ReadLockFromFile(project.LockFilePath())
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(lock.Dependencies) != 1 {
+						t.Fatalf("expected exactly one locked dependency, got %v", lock.Dependencies)
+					}
+					return lock
+				}
+				policyFile := filepath.Join(path, ".opa", "dependencies", DepId("", location), "policy.rego")
+
+				lock := update(UpdateOptions{})
+				if lock.Dependencies[0].Revision != first {
+					t.Fatalf("expected locked revision %s, got %s", first, lock.Dependencies[0].Revision)
+				}
+				lockedDigest := lock.Dependencies[0].Digest
+
+				second := commitFile(t, repo, "policy.rego", "package lib\n\nx := 2\n")
+
+				lock = update(UpdateOptions{})
+				if lock.Dependencies[0].Revision != first {
+					t.Fatalf("expected locked revision %s to be kept, got %s", first, lock.Dependencies[0].Revision)
+				}
+				if lock.Dependencies[0].Digest != lockedDigest {
+					t.Fatalf("expected digest %s to be kept, got %s", lockedDigest, lock.Dependencies[0].Digest)
+				}
+				expectFileContent(t, policyFile, "package lib\n\nx := 1\n")
+
+				lock = update(UpdateOptions{Refresh: true})
+				if lock.Dependencies[0].Revision != second {
+					t.Fatalf("expected refreshed revision %s, got %s", second, lock.Dependencies[0].Revision)
+				}
+				expectFileContent(t, policyFile, "package lib\n\nx := 2\n")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFrozenUpdate(t *testing.T) {
+	tests := []struct {
+		note        string
+		lock        func(path string) string
+		modify      func(path string) error
+		expectedErr string
+	}{
+		{
+			note: "lock matches",
+		},
+		{
+			note: "no lock file",
+			lock: func(string) string {
+				return ""
+			},
+			expectedErr: "dependency lib (file:/lib) is missing from lock file",
+		},
+		{
+			note: "undeclared dependency in lock file",
+			lock: func(path string) string {
+				b, _ := os.ReadFile(filepath.Join(path, lockFileName))
+				return string(b) + `    - name: old
+      location: file:/old
+      namespace: old
+      digest: sha256:0
+`
+			},
+			modify: func(path string) error {
+				// Left behind by the undeclared dependency
+				dir := filepath.Join(dependenciesDir(path), DepId("old", "file:/old"))
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, "policy.rego"), []byte("package old\n"), 0644)
+			},
+			expectedErr: "lock file contains dependency old (file:/old), which is no longer declared",
+		},
+		{
+			note: "changed dependency content",
+			modify: func(path string) error {
+				return os.WriteFile(filepath.Join(path, "lib", "policy.rego"), []byte("package lib\n\nx := 2\n"), 0644)
+			},
+			expectedErr: "content of dependency lib (file:/lib) does not match lock file",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": `dependencies:
+  lib:
+    location: file:/lib
+    namespace: false
+`,
+				"lib/policy.rego": "package lib\n\nx := 1\n",
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+
+				if tc.lock != nil {
+					if err := os.WriteFile(project.LockFilePath(), []byte(tc.lock(path)), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tc.modify != nil {
+					if err := tc.modify(path); err != nil {
+						t.Fatal(err)
+					}
+				}
+				before, err := os.ReadFile(project.LockFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				depsBefore, err := utils.HashDir(dependenciesDir(path), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = project.Update(UpdateOptions{Frozen: true})
+				if tc.expectedErr == "" && err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				} else if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+
+				expectFileContent(t, project.LockFilePath(), string(before))
+
+				// Dependency directories are neither rewritten nor pruned
+				if depsAfter, err := utils.HashDir(dependenciesDir(path), nil); err != nil {
+					t.Fatal(err)
+				} else if depsAfter != depsBefore {
+					t.Fatal("expected dependency directories to be unchanged")
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockFileName)
+
+	lock := &Lock{}
+	lock.add(LockedDependency{Name: "b", Location: "file:/b", Namespace: "b", Revision: "sha256:b", Digest: "sha256:b"})
+	lock.add(LockedDependency{Name: "a", Location: "file:/a", Namespace: "a", Revision: "sha256:a", Digest: "sha256:a"})
+	lock.add(LockedDependency{Name: "b", Location: "file:/b", Namespace: "b", Revision: "sha256:c", Digest: "sha256:c"})
+
+	if err := lock.WriteToFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFileContent(t, path, `# This file is generated by odm. Do not edit it manually.
+dependencies:
+    - name: a
+      location: file:/a
+      namespace: a
+      revision: sha256:a
+      digest: sha256:a
+    - name: b
+      location: file:/b
+      namespace: b
+      revision: sha256:c
+      digest: sha256:c
+`)
+
+	read, err := ReadLockFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep := read.find(DepId("b", "file:/b")); dep == nil || dep.Revision != "sha256:c" {
+		t.Fatalf("expected locked dependency b at revision sha256:c, got %v", dep)
+	}
+}
+
+func expectFileContent(t *testing.T, path, expected string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("expected file '%s' to contain:\n\n%s\n\ngot:\n\n%s", path, expected, string(b))
+	}
+}
@@ -0,0 +1,227 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// namespaceModules moves the Rego modules found in dirs from the root of data to data.<namespace>, by rewriting
+// their package declarations, imports, and all other references to data.
+// Modules are parsed and formatted in-process, and reported by their path relative to root on failure.
+// Files without any statements, such as empty files, are left as they are.
+func namespaceModules(ctx context.Context, root string, dirs []string, namespace string) error {
+	target, err := ast.ParseRef(fmt.Sprintf("data.%s", namespace))
+	if err != nil {
+		return fmt.Errorf("invalid namespace %s: %w", namespace, err)
+	}
+
+	files, err := regoFiles(dirs, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			name = file
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		namespaced, err := namespaceModule(name, data, target)
+		if err != nil {
+			return err
+		}
+		if namespaced == nil {
+			printer.From(ctx).Debug("Skipping %s, without statements", name)
+			continue
+		}
+
+		if err := os.WriteFile(file, namespaced, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// namespaceModule returns the module in data, named name, with all references to data rewritten to be rooted at
+// target; or nil if data contains no statements.
+func namespaceModule(name string, data []byte, target ast.Ref) ([]byte, error) {
+	module, err := ast.ParseModule(name, string(data))
+	if err != nil {
+		if stmts, _, stmtsErr := ast.ParseStatements(name, string(data)); stmtsErr == nil && len(stmts) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to parse module: %w", err)
+	}
+
+	if _, err := ast.TransformRefs(module, func(ref ast.Ref) (ast.Value, error) {
+		if ref.HasPrefix(ast.DefaultRootRef) {
+			return target.Concat(ref[len(ast.DefaultRootRef):]), nil
+		}
+		return ref, nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to rewrite module %s: %w", name, err)
+	}
+
+	formatted, err := format.Ast(module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format module %s: %w", name, err)
+	}
+	return formatted, nil
+}
+
+// regoFiles returns the paths of all Rego files in the given files and directory trees, sorted and without
+// duplicates. Directories in skip are left out.
+func regoFiles(dirs []string, skip map[string]bool) ([]string, error) {
+	found := map[string]bool{}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && path != dir && skip[filepath.Clean(path)] {
+				return filepath.SkipDir
+			}
+			if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".rego") {
+				found[filepath.Clean(path)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Rego files in %s: %w", dir, err)
+		}
+	}
+
+	files := make([]string, 0, len(found))
+	for file := range found {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// namespaceDataDocuments moves the JSON and YAML data documents found in dirs from the root of data to
+// data.<namespace>, by nesting them in the directories of the namespace. As the documents' locations in data are
+// given by their directories relative to the dir they're loaded from, a document is moved within the first of dirs
+// containing it.
+func namespaceDataDocuments(ctx context.Context, dirs []string, namespace string) error {
+	nsPath := filepath.Join(strings.Split(namespace, ".")...)
+
+	roots := map[string]bool{}
+	for _, dir := range dirs {
+		roots[filepath.Clean(dir)] = true
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		var docs []string
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			path = filepath.Clean(path)
+			if !entry.Type().IsRegular() || !isDataDocument(entry.Name()) || seen[path] {
+				return nil
+			}
+			seen[path] = true
+			if path == filepath.Clean(dir) {
+				printer.From(ctx).Debug("Skipping data document %s, loaded by itself", path)
+				return nil
+			}
+			docs = append(docs, path)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list data documents in %s: %w", dir, err)
+		}
+		if len(docs) == 0 {
+			continue
+		}
+
+		if err := moveDataDocuments(dir, nsPath, docs, roots); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveDataDocuments moves docs, found in dir, to the same paths relative to dir/nsPath; removing the directories
+// emptied by the move, other than roots. Documents are moved through a staging dir, as a document may be moved to
+// where another one is.
+func moveDataDocuments(dir, nsPath string, docs []string, roots map[string]bool) error {
+	staging, err := os.MkdirTemp(dir, ".staging-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	for _, doc := range docs {
+		rel, err := filepath.Rel(dir, doc)
+		if err != nil {
+			return err
+		}
+		if err := moveFile(doc, filepath.Join(staging, rel)); err != nil {
+			return err
+		}
+		removeEmptyDirs(filepath.Dir(doc), dir, roots)
+	}
+	for _, doc := range docs {
+		rel, err := filepath.Rel(dir, doc)
+		if err != nil {
+			return err
+		}
+		if err := moveFile(filepath.Join(staging, rel), filepath.Join(dir, nsPath, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDataDocument(name string) bool {
+	switch filepath.Ext(name) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(to), err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("failed to move %s: %w", from, err)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir, and its parents up to, but not including, root, for as long as they're empty and not
+// in keep.
+func removeEmptyDirs(dir, root string, keep map[string]bool) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if keep[dir] {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package proj
+
+import (
+	"context"
+	"github.com/johanfylling/odm/utils"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceModules(t *testing.T) {
+	tests := []struct {
+		note        string
+		namespace   string
+		files       map[string]string
+		dirs        []string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			note:      "package, imports, and references",
+			namespace: "dep",
+			files: map[string]string{
+				"src/lib.rego": `# The library
+package lib.x
+
+import future.keywords.if
+import data.other
+import input.user
+
+# Allowed users
+allow if {
+	data.lib.x.users[_] == user
+	other.enabled
+	not data.admin.deny with data.admin.users as []
+}
+
+root := data
+`,
+			},
+			dirs: []string{"src"},
+			expected: map[string]string{
+				"src/lib.rego": `# The library
+package dep.lib.x
+
+import data.dep.other
+import future.keywords.if
+import input.user
+
+# Allowed users
+allow if {
+	data.dep.lib.x.users[_] == user
+	other.enabled
+	not data.dep.admin.deny with data.dep.admin.users as []
+}
+
+root := data.dep
+`,
+			},
+		},
+		{
+			note:      "nested namespace",
+			namespace: "a.b",
+			files: map[string]string{
+				"policy.rego": "package main\n\np := data.main.q\n\nq := true\n",
+			},
+			dirs: []string{"."},
+			expected: map[string]string{
+				"policy.rego": "package a.b.main\n\np := data.a.b.main.q\n\nq := true\n",
+			},
+		},
+		{
+			note:      "overlapping dirs",
+			namespace: "dep",
+			files: map[string]string{
+				"policy.rego":         "package main\n",
+				"tests/test.rego":     "package main_test\n",
+				"tests/data.json":     `{"x": 1}`,
+				"tests/README.md":     "package main\n",
+				"tests/empty.rego":    "",
+				"tests/notes.rego":    "# Only comments\n",
+				"tests/nested/p.rego": "package nested\n",
+			},
+			dirs: []string{".", "tests"},
+			expected: map[string]string{
+				"policy.rego":         "package dep.main\n",
+				"tests/test.rego":     "package dep.main_test\n",
+				"tests/data.json":     `{"x": 1}`,
+				"tests/README.md":     "package main\n",
+				"tests/empty.rego":    "",
+				"tests/notes.rego":    "# Only comments\n",
+				"tests/nested/p.rego": "package dep.nested\n",
+			},
+		},
+		{
+			note:      "parse error",
+			namespace: "dep",
+			files: map[string]string{
+				"src/ok.rego":     "package ok\n",
+				"src/broken.rego": "package broken\n\nallow {\n\tinput.x ==\n}\n",
+			},
+			dirs:        []string{"src"},
+			expectedErr: "src/broken.rego:5: rego_parse_error: unexpected } token",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(tc.files, func(root string) {
+				var dirs []string
+				for _, dir := range tc.dirs {
+					dirs = append(dirs, filepath.Join(root, dir))
+				}
+
+				err := namespaceModules(context.Background(), root, dirs, tc.namespace)
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for path, expected := range tc.expected {
+					actual, err := os.ReadFile(filepath.Join(root, path))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(actual) != expected {
+						t.Fatalf("expected %s:\n%s\ngot:\n%s", path, expected, actual)
+					}
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNamespaceDataDocuments(t *testing.T) {
+	tests := []struct {
+		note      string
+		namespace string
+		files     map[string]string
+		dirs      []string
+		expected  []string
+	}{
+		{
+			note:      "source dirs",
+			namespace: "dep",
+			files: map[string]string{
+				"src/policy.rego":      "package main\n",
+				"data/data.json":       `{"a": 1}`,
+				"data/foo/data.yaml":   "b: 2\n",
+				"data/foo/bar/x.yml":   "c: 3\n",
+				"data/README.md":       "",
+				"opa.project":          "source: [src, data]\n",
+				"unloaded/data.json":   `{}`,
+				"data/dep/nested.json": `{"d": 4}`,
+			},
+			dirs: []string{"src", "data"},
+			expected: []string{
+				"data/README.md",
+				"data/dep/data.json",
+				"data/dep/dep/nested.json",
+				"data/dep/foo/bar/x.yml",
+				"data/dep/foo/data.yaml",
+				"opa.project",
+				"src/policy.rego",
+				"unloaded/data.json",
+			},
+		},
+		{
+			note:      "nested namespace, overlapping dirs",
+			namespace: "a.b",
+			files: map[string]string{
+				"data.json":       `{}`,
+				"tests/data.json": `{}`,
+			},
+			dirs: []string{".", "tests"},
+			expected: []string{
+				"a/b/data.json",
+				"a/b/tests/data.json",
+			},
+		},
+		{
+			note:      "no data documents",
+			namespace: "dep",
+			files: map[string]string{
+				"policy.rego": "package main\n",
+			},
+			dirs:     []string{"."},
+			expected: []string{"policy.rego"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(tc.files, func(root string) {
+				var dirs []string
+				for _, dir := range tc.dirs {
+					dirs = append(dirs, filepath.Join(root, dir))
+				}
+
+				if err := namespaceDataDocuments(context.Background(), dirs, tc.namespace); err != nil {
+					t.Fatal(err)
+				}
+
+				var actual []string
+				err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					// Emptied dirs are removed, unless loaded from
+					if path != root && entry.IsDir() && !utils.Contains(dirs, path) {
+						if entries, err := os.ReadDir(path); err != nil {
+							return err
+						} else if len(entries) == 0 {
+							t.Errorf("unexpected empty directory %s", path)
+						}
+					}
+					if entry.Type().IsRegular() {
+						rel, _ := filepath.Rel(root, path)
+						actual = append(actual, filepath.ToSlash(rel))
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(actual, tc.expected) {
+					t.Fatalf("expected files:\n%v\ngot:\n%v", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNamespaceDataDocumentsFailureRemovesStaging(t *testing.T) {
+	files := map[string]string{
+		"data.json": `{"x": 1}`,
+		// A file in the way of the namespace's directory fails the move
+		"lib": "not a directory",
+	}
+	err := withTempFiles(files, func(root string) {
+		if err := namespaceDataDocuments(context.Background(), []string{root}, "lib"); err == nil {
+			t.Fatal("expected error")
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".staging-") {
+				t.Fatalf("expected staging directory to be removed, got %s", entry.Name())
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
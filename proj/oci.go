@@ -0,0 +1,253 @@
+package proj
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"net"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociPrefix   = "oci://"
+	cacheOciDir = "oci"
+
+	ociUsernameEnv = "ODM_OCI_USERNAME"
+	ociPasswordEnv = "ODM_OCI_PASSWORD"
+
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+func isOciLocation(location string) bool {
+	return strings.HasPrefix(location, ociPrefix)
+}
+
+// ociReference parses the location of an OCI dependency; oci://<registry>/<repository>[:<tag>|@<digest>].
+func (d Dependency) ociReference() (registry.Reference, error) {
+	ref, err := registry.ParseReference(strings.TrimPrefix(d.Location, ociPrefix))
+	if err != nil {
+		return registry.Reference{}, fmt.Errorf("invalid OCI location %s: %w", d.Location, err)
+	}
+	return ref, nil
+}
+
+// ociDigest returns the manifest digest declared in the location of an OCI dependency; if any.
+func (d Dependency) ociDigest() (string, error) {
+	ref, err := d.ociReference()
+	if err != nil {
+		return "", err
+	}
+	if dgst, err := ref.Digest(); err == nil {
+		return dgst.String(), nil
+	}
+	return "", nil
+}
+
+// ociRepository returns the remote repository of ref. Registries on the loopback interface, such as a local
+// development registry, are accessed over plain HTTP.
+// Credentials are read from the ODM_OCI_USERNAME and ODM_OCI_PASSWORD environment variables, if set; otherwise the
+// registry is accessed anonymously.
+func ociRepository(ref registry.Reference) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(ref.Registry + "/" + ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+	repo.PlainHTTP = isLoopbackHost(ref.Host())
+
+	client := &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+	}
+	if username, password := os.Getenv(ociUsernameEnv), os.Getenv(ociPasswordEnv); username != "" || password != "" {
+		client.Credential = auth.StaticCredential(ref.Host(), auth.Credential{
+			Username: username,
+			Password: password,
+		})
+	}
+	client.SetUserAgent("odm")
+	repo.Client = client
+	return repo, nil
+}
+
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// cachedOciDir returns the cache directory holding the content of the OCI artifact with the given manifest digest.
+func cachedOciDir(manifestDigest string) (string, error) {
+	root, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	dgst, err := digest.Parse(manifestDigest)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest digest %s: %w", manifestDigest, err)
+	}
+	return filepath.Join(root, cacheOciDir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// fetchOci pulls the OCI artifact of the dependency, and writes its layers into the cache; returning the directory of
+// its content, and the digest of its manifest. The manifest, and every layer, is verified against its digest.
+// If expected is set, the manifest with that digest is pulled, rather than the one the location refers to; and isn't
+// pulled again if it's already cached. If offline, it must have been.
+// Gzipped tarball layers, such as the layer of an OPA bundle, are extracted; other layers are written to the file
+// named by their title annotation.
+func (d Dependency) fetchOci(ctx context.Context, expected string, offline bool) (dir string, manifestDigest string, err error) {
+	ref, err := d.ociReference()
+	if err != nil {
+		return "", "", err
+	}
+	if expected != "" {
+		if dir, err := cachedOciDir(expected); err != nil {
+			return "", "", err
+		} else if utils.FileExists(dir) {
+			printer.From(ctx).Debug("Using cached OCI artifact %s for %s", expected, d.Location)
+			return dir, expected, nil
+		}
+	}
+	if offline {
+		return "", "", fmt.Errorf("OCI artifact %s %w", d.Location, errNotCached)
+	}
+
+	repo, err := ociRepository(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid OCI location %s: %w", d.Location, err)
+	}
+	reference := ref.ReferenceOrDefault()
+	if expected != "" {
+		reference = expected
+	}
+
+	printer.From(ctx).Debug("Pulling OCI artifact %s", d.Location)
+	desc, rc, err := repo.FetchReference(ctx, reference)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to pull manifest of %s: %w", d.Location, err)
+	}
+	data, err := content.ReadAll(rc, desc)
+	_ = rc.Close()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to pull manifest of %s: %w", d.Location, err)
+	}
+	if actual := digest.FromBytes(data); actual != desc.Digest {
+		return "", "", fmt.Errorf("manifest digest of %s does not match; expected %s, got %s", d.Location, desc.Digest, actual)
+	}
+	if expected != "" && desc.Digest.String() != expected {
+		return "", "", fmt.Errorf("manifest digest of %s does not match; expected %s, got %s", d.Location, expected, desc.Digest)
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, dockerManifestMediaType:
+	default:
+		return "", "", fmt.Errorf("unsupported manifest type %s of %s; expected an image manifest", desc.MediaType, d.Location)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", "", fmt.Errorf("invalid manifest of %s: %w", d.Location, err)
+	}
+
+	ociDir, err := cachedOciDir(desc.Digest.String())
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(ociDir), 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(ociDir), ".tmp-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	contentDir := filepath.Join(tmpDir, "content")
+	w := newArchiveWriter(contentDir)
+	if err := os.MkdirAll(contentDir, 0755); err != nil {
+		return "", "", err
+	}
+	for i, layer := range manifest.Layers {
+		blob := filepath.Join(tmpDir, fmt.Sprintf("layer-%d", i))
+		if err := pullBlob(ctx, repo, layer, blob); err != nil {
+			return "", "", fmt.Errorf("failed to pull layer %s of %s: %w", layer.Digest, d.Location, err)
+		}
+
+		title := layer.Annotations[ocispec.AnnotationTitle]
+		switch {
+		case strings.HasSuffix(layer.MediaType, "tar+gzip"):
+			err = extractTarGz(ctx, blob, w)
+		case title != "":
+			err = func() error {
+				f, err := os.Open(blob)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					_ = f.Close()
+				}()
+				return w.writeFile(title, f)
+			}()
+		default:
+			printer.From(ctx).Warn("Skipping layer %s of %s, of media type %s, without title", layer.Digest, d.Location, layer.MediaType)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to extract layer %s of %s: %w", layer.Digest, d.Location, err)
+		}
+	}
+
+	if err := os.Rename(contentDir, ociDir); err != nil && !utils.FileExists(ociDir) {
+		return "", "", fmt.Errorf("failed to write OCI artifact %s to cache: %w", d.Location, err)
+	}
+	return ociDir, desc.Digest.String(), nil
+}
+
+// pullBlob writes the blob described by desc to file, verifying it against its digest and size.
+func pullBlob(ctx context.Context, repo *remote.Repository, desc ocispec.Descriptor, file string) error {
+	rc, err := repo.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	vr := content.NewVerifyReader(rc, desc)
+	if _, err := io.Copy(f, vr); err != nil {
+		if errors.Is(err, content.ErrTrailingData) || errors.Is(err, content.ErrMismatchedDigest) {
+			return fmt.Errorf("blob doesn't match its digest: %w", err)
+		}
+		return err
+	}
+	if err := vr.Verify(); err != nil {
+		return fmt.Errorf("blob doesn't match its digest: %w", err)
+	}
+	return f.Close()
+}
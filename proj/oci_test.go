@@ -0,0 +1,306 @@
+package proj
+
+import (
+	"encoding/json"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry is an in-process stand-in for an OCI registry, serving the manifests and blobs pushed to it; by the
+// test, or through the registry API.
+type testRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[string][]byte
+	requests  int
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		blobs:     map[string][]byte{},
+	}
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	if req.URL.Path == "/v2/" {
+		return
+	}
+	if repo, _, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/uploads/"); ok {
+		// Blobs are uploaded monolithically; started by a POST, and completed by a PUT of the blob to the returned location
+		switch req.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/upload")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			data, err := io.ReadAll(req.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.blobs[repo+"@"+req.URL.Query().Get("digest")] = data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	repo, ref, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/")
+	if ok && req.Method == http.MethodPut {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, ref := range []string{ref, digest.FromBytes(data).String()} {
+			r.manifests[repo+"@"+ref] = data
+			r.types[repo+"@"+ref] = req.Header.Get("Content-Type")
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if ok {
+		data, found := r.manifests[repo+"@"+ref]
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[repo+"@"+ref])
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if req.Method != http.MethodHead {
+			_, _ = w.Write(data)
+		}
+		return
+	}
+	if repo, ref, ok = strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/"); ok {
+		data, found := r.blobs[repo+"@"+ref]
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// push pushes an artifact with the given layers to repo, tagged with tag; returning the digest of its manifest.
+func (r *testRegistry) push(t *testing.T, repo, tag, mediaType string, layers ...ocispec.Descriptor) string {
+	t.Helper()
+	manifest := ocispec.Manifest{
+		MediaType: mediaType,
+		Config:    r.blob(repo, ocispec.MediaTypeImageConfig, []byte("{}"), ""),
+		Layers:    layers,
+	}
+	manifest.SchemaVersion = 2
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(data).String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ref := range []string{tag, dgst} {
+		r.manifests[repo+"@"+ref] = data
+		r.types[repo+"@"+ref] = mediaType
+	}
+	return dgst
+}
+
+// blob adds a blob to repo, returning its descriptor; annotated with title, if set.
+func (r *testRegistry) blob(repo, mediaType string, data []byte, title string) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if title != "" {
+		desc.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[repo+"@"+desc.Digest.String()] = data
+	return desc
+}
+
+func TestUpdateOciDependency(t *testing.T) {
+	registry := newTestRegistry()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	filesDigest := registry.push(t, "policies/files", "v1", ocispec.MediaTypeImageManifest,
+		registry.blob("policies/files", "application/vnd.oci.image.layer.v1.tar", []byte("package x\n\np := true\n"), "x/policy.rego"),
+		registry.blob("policies/files", "application/vnd.oci.image.layer.v1.tar", []byte(`{"y": 1}`), "data.json"),
+		registry.blob("policies/files", "application/octet-stream", []byte("ignored"), ""))
+	registry.push(t, "policies/bundle", "latest", ocispec.MediaTypeImageManifest,
+		registry.blob("policies/bundle", ocispec.MediaTypeImageLayerGzip, tarGzArchive(t, map[string]string{
+			".manifest":             `{"roots": ["authz"]}`,
+			"authz/policy.rego":     "package authz\n\nallow := data.authz.users[input.user]\n",
+			"authz/users/data.json": `{"alice": true}`,
+		}), ""))
+	registry.push(t, "policies/index", "v1", ocispec.MediaTypeImageIndex)
+	tampered := registry.blob("policies/tampered", "application/vnd.oci.image.layer.v1.tar", []byte("package t\n"), "t.rego")
+	registry.blobs["policies/tampered@"+tampered.Digest.String()] = []byte("package x\n")
+	registry.push(t, "policies/tampered", "v1", ocispec.MediaTypeImageManifest, tampered)
+	otherDigest := digest.FromString("other").String()
+
+	tests := []struct {
+		note          string
+		dependency    string
+		expectedFiles map[string]string
+		expectedErr   string
+	}{
+		{
+			note:       "tag",
+			dependency: "oci://" + host + "/policies/files:v1",
+			expectedFiles: map[string]string{
+				"x/policy.rego": "package lib.x\n\np := true\n",
+				"lib/data.json": `{"y": 1}`,
+			},
+		},
+		{
+			note:       "digest",
+			dependency: "oci://" + host + "/policies/files@" + filesDigest,
+			expectedFiles: map[string]string{
+				"x/policy.rego": "package lib.x\n\np := true\n",
+			},
+		},
+		{
+			note:       "bundle",
+			dependency: "oci://" + host + "/policies/bundle",
+			expectedFiles: map[string]string{
+				"authz/policy.rego":         "package lib.authz\n\nallow := data.lib.authz.users[input.user]\n",
+				"lib/authz/users/data.json": `{"alice": true}`,
+				".manifest": `{
+  "roots": [
+    "lib/authz"
+  ]
+}`,
+			},
+		},
+		{
+			note:        "unknown digest",
+			dependency:  "oci://" + host + "/policies/files@" + otherDigest,
+			expectedErr: "failed to pull manifest of oci://" + host + "/policies/files@" + otherDigest,
+		},
+		{
+			note:        "unknown tag",
+			dependency:  "oci://" + host + "/policies/files:v2",
+			expectedErr: "failed to pull manifest of oci://" + host + "/policies/files:v2",
+		},
+		{
+			note:        "index",
+			dependency:  "oci://" + host + "/policies/index:v1",
+			expectedErr: "unsupported manifest type " + ocispec.MediaTypeImageIndex,
+		},
+		{
+			note:        "tampered layer",
+			dependency:  "oci://" + host + "/policies/tampered:v1",
+			expectedErr: "failed to pull layer " + tampered.Digest.String(),
+		},
+		{
+			note:        "invalid location",
+			dependency:  "oci://" + host,
+			expectedErr: "invalid OCI location oci://" + host,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": "dependencies:\n  lib: " + tc.dependency + "\n",
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				libDir := project.Dependencies["lib"].dir(dependenciesDir(path))
+				for file, expected := range tc.expectedFiles {
+					expectFileContent(t, filepath.Join(libDir, file), expected)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateOciDependencyFromCache(t *testing.T) {
+	registry := newTestRegistry()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	registry.push(t, "policies/cached", "v1", ocispec.MediaTypeImageManifest,
+		registry.blob("policies/cached", "application/vnd.oci.image.layer.v1.tar", []byte("package cached\n"), "cached.rego"))
+
+	files := map[string]string{
+		"opa.project": "dependencies:\n  lib: oci://" + host + "/policies/cached:v1\n",
+	}
+	err := withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+			t.Fatal(err)
+		}
+
+		registry.mu.Lock()
+		before := registry.requests
+		registry.mu.Unlock()
+
+		project, err = ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{Offline: true}); err != nil {
+			t.Fatal(err)
+		}
+		expectFileContent(t, filepath.Join(project.Dependencies["lib"].dir(dependenciesDir(path)), "cached.rego"),
+			"package lib.cached\n")
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		if registry.requests != before {
+			t.Fatalf("expected artifact to be materialized from cache, got %d requests", registry.requests-before)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+package proj
+
+import (
+	"context"
+	"strings"
+)
+
+// OutdatedDependency lists the versions available for a git dependency.
+type OutdatedDependency struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Location  string `json:"location"`
+	// Current is the ref the dependency is locked at, or, if it isn't locked, the declared ref; 'HEAD' if none
+	Current string `json:"current"`
+	// Revision is the commit SHA the dependency is locked at
+	Revision   string `json:"revision,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+	// Wanted is the newest tag matching the declared version constraint, if any
+	Wanted string `json:"wanted,omitempty"`
+	// Latest is the newest tag with a semantic version, pre-releases excluded
+	Latest string `json:"latest,omitempty"`
+}
+
+// Outdated lists the current, wanted, and latest versions of every git dependency of the loaded project, including
+// transitive dependencies, depth first and sorted by name. Tags are listed from the remote repositories, or, if
+// offline is set, from the dependency cache.
+func (p *Project) Outdated(ctx context.Context, offline bool) ([]OutdatedDependency, error) {
+	lock, err := ReadLockFromFile(p.LockFilePath())
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []Dependency
+	seen := map[string]bool{}
+	var walk func(p *Project)
+	walk = func(p *Project) {
+		if p == nil {
+			return
+		}
+		for _, name := range p.dependencyNames() {
+			dep := p.Dependencies[name]
+			if strings.HasPrefix(dep.Location, "git+") && !seen[dep.id()] {
+				seen[dep.id()] = true
+				deps = append(deps, dep)
+			}
+			walk(dep.Project)
+		}
+	}
+	walk(p)
+
+	tagsByUrl := map[string][]string{}
+	outdated := make([]OutdatedDependency, 0, len(deps))
+	for _, dep := range deps {
+		url, ref, constraint, err := dep.gitRef()
+		if err != nil {
+			return nil, err
+		}
+
+		o := OutdatedDependency{
+			Name:       dep.Name,
+			Namespace:  dep.fullNamespace(),
+			Location:   dep.Location,
+			Current:    ref.name,
+			Constraint: constraint,
+		}
+		if locked := lock.find(dep.id()); locked != nil {
+			o.Current = locked.Ref
+			o.Revision = locked.Revision
+		}
+		if o.Current == "" {
+			o.Current = "HEAD"
+		}
+
+		tags, ok := tagsByUrl[url]
+		if !ok {
+			if tags, err = listGitTags(ctx, url, offline); err != nil {
+				return nil, err
+			}
+			tagsByUrl[url] = tags
+		}
+		if constraint != "" {
+			// No tag matching the constraint is reported as no wanted version
+			o.Wanted, _ = selectVersion(constraint, tags)
+		}
+		o.Latest = latestVersion(tags)
+
+		outdated = append(outdated, o)
+	}
+	return outdated, nil
+}
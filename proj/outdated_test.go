@@ -0,0 +1,80 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestOutdated(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	revisions := map[string]string{}
+	tag := func(tags ...string) {
+		for _, tag := range tags {
+			revisions[tag] = commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nversion := \"%s\"\n", tag))
+			tagCommit(t, repo, tag, revisions[tag])
+		}
+	}
+	tag("v1.0.0", "v1.1.0", "v2.0.0")
+	location := fmt.Sprintf("git+file://%s", repoDir)
+
+	files := map[string]string{
+		"opa.project": fmt.Sprintf(`dependencies:
+  constrained:
+    location: %s
+    version: ^1.0.0
+  pinned: %s#v1.0.0
+  local: file:/local
+`, location, location),
+		"local/policy.rego": "package local\n",
+	}
+	err := withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Released after the dependencies were locked
+		tag("v1.2.0", "v2.1.0", "v3.0.0-rc1")
+
+		if err := project.Load(); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := project.Outdated(context.Background(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []OutdatedDependency{
+			{
+				Name:       "constrained",
+				Namespace:  "constrained",
+				Location:   location,
+				Current:    "v1.1.0",
+				Revision:   revisions["v1.1.0"],
+				Constraint: "^1.0.0",
+				Wanted:     "v1.2.0",
+				Latest:     "v2.1.0",
+			},
+			{
+				Name:      "pinned",
+				Namespace: "pinned",
+				Location:  location + "#v1.0.0",
+				Current:   "v1.0.0",
+				Revision:  revisions["v1.0.0"],
+				Latest:    "v2.1.0",
+			},
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected:\n%+v\ngot:\n%+v", expected, actual)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
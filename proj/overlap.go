@@ -0,0 +1,264 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"github.com/open-policy-agent/opa/ast"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Overlap is a path in data that more than one of the project's sources and dependencies contribute to.
+type Overlap struct {
+	// Path is the conflicting path; the longer one, if a path of one contributor is a prefix of a path of the other
+	Path string
+	// First and Second name the contributors; the project, or a dependency by its path from the project
+	First  string
+	Second string
+}
+
+func (o Overlap) String() string {
+	return fmt.Sprintf("%s is contributed to by both %s and %s", o.Path, o.First, o.Second)
+}
+
+// contribution is the set of package and data paths a contributor adds to data.
+type contribution struct {
+	name     string
+	packages []ast.Ref
+	data     []ast.Ref
+}
+
+// Overlaps returns the paths in data that more than one of the loaded project's source dirs and dependencies
+// contribute to; either by declaring the same package, or by declaring a data document at, or under, a path another
+// one declares a package or data document at.
+func (p *Project) Overlaps() ([]Overlap, error) {
+	var deps []Dependency
+	if err := WalkDependencies(p, func(dep Dependency) error {
+		deps = append(deps, dep)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return p.overlaps(deps)
+}
+
+// CheckOverlaps returns an error listing the overlaps of the loaded project; or, if allow is set, prints them as
+// warnings.
+func (p *Project) CheckOverlaps(allow bool) error {
+	overlaps, err := p.Overlaps()
+	if err != nil {
+		return err
+	}
+	return checkOverlaps(overlaps, allow)
+}
+
+func checkOverlaps(overlaps []Overlap, allow bool) error {
+	if len(overlaps) == 0 {
+		return nil
+	}
+	if allow {
+		for _, o := range overlaps {
+			printer.Warn("%s", o)
+		}
+		return nil
+	}
+	lines := make([]string, 0, len(overlaps))
+	for _, o := range overlaps {
+		lines = append(lines, "  "+o.String())
+	}
+	return fmt.Errorf("dependencies overlap in data:\n%s", strings.Join(lines, "\n"))
+}
+
+// overlaps returns the overlaps between the project's source dirs and the materialized deps.
+func (p *Project) overlaps(deps []Dependency) ([]Overlap, error) {
+	sources, err := p.sourceLocations()
+	if err != nil {
+		return nil, err
+	}
+	// The dependency directory, and the sources of local dependencies, found in the project's source dirs are
+	// contributed by the dependencies themselves, as materialized
+	rootDir := filepath.Dir(p.filePath)
+	skip := map[string]bool{filepath.Join(rootDir, ".opa"): true}
+	for _, dep := range deps {
+		if key := dep.libraryKey(rootDir); strings.HasPrefix(key, "file:") {
+			skip[strings.TrimPrefix(key, "file:")] = true
+		}
+	}
+	root, err := collectContribution("the project", utils.FilterExistingFiles(sources), skip)
+	if err != nil {
+		return nil, err
+	}
+	contributions := []contribution{root}
+
+	// The same dependency may be reached through more than one path, or be materialized with the same content as
+	// another one, such as libraries deduplicated to the same version; and then contributes only once
+	sort.SliceStable(deps, func(i, j int) bool {
+		return deps[i].path() < deps[j].path()
+	})
+	depsRootDir := dependenciesDir(rootDir)
+	seen := map[string]bool{}
+	for _, dep := range deps {
+		key := dep.id()
+		if state := readDependencyState(context.Background(), depsRootDir, dep.id()); state != nil && state.Materialized != "" {
+			key = state.Materialized
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		c, err := collectContribution(fmt.Sprintf("dependency %s", dep.path()), utils.FilterExistingFiles(dep.SourceDirs()), nil)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, c)
+	}
+
+	var overlaps []Overlap
+	found := map[Overlap]bool{}
+	add := func(path ast.Ref, first, second contribution) {
+		o := Overlap{Path: path.String(), First: first.name, Second: second.name}
+		if !found[o] {
+			found[o] = true
+			overlaps = append(overlaps, o)
+		}
+	}
+	for i, first := range contributions {
+		for _, second := range contributions[i+1:] {
+			for _, a := range first.packages {
+				for _, b := range second.packages {
+					if a.Equal(b) {
+						add(a, first, second)
+					}
+				}
+			}
+			secondPaths := make([]ast.Ref, 0, len(second.packages)+len(second.data))
+			secondPaths = append(append(secondPaths, second.packages...), second.data...)
+			for _, pair := range [][2][]ast.Ref{
+				{first.data, secondPaths},
+				{first.packages, second.data},
+			} {
+				for _, a := range pair[0] {
+					for _, b := range pair[1] {
+						if b.HasPrefix(a) {
+							add(b, first, second)
+						} else if a.HasPrefix(b) {
+							add(a, first, second)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(overlaps, func(i, j int) bool {
+		return overlaps[i].Path < overlaps[j].Path
+	})
+	return overlaps, nil
+}
+
+// sourceLocations returns the source dirs of the project; or the project dir, if none are declared.
+func (p *Project) sourceLocations() ([]string, error) {
+	projDir := filepath.Dir(p.filePath)
+	if len(p.SourceDirs) == 0 {
+		return []string{projDir}, nil
+	}
+	locations := make([]string, 0, len(p.SourceDirs))
+	for _, dir := range p.SourceDirs {
+		dir, err := utils.NormalizeFilePath(dir)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, filepath.Join(projDir, dir))
+	}
+	return locations, nil
+}
+
+// collectContribution collects the packages declared by the Rego modules, and the paths of the data documents and
+// bundle roots, found in dirs, leaving out directories in skip. Modules and documents that can't be parsed are
+// skipped; they're reported by OPA when loaded.
+func collectContribution(name string, dirs []string, skip map[string]bool) (contribution, error) {
+	c := contribution{name: name}
+
+	files, err := regoFiles(dirs, skip)
+	if err != nil {
+		return c, err
+	}
+	packages := map[string]bool{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return c, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		module, err := ast.ParseModule(file, string(data))
+		if err != nil || module == nil {
+			printer.Debug("Skipping module %s, without package", file)
+			continue
+		}
+		if path := module.Package.Path; !packages[path.String()] {
+			packages[path.String()] = true
+			c.packages = append(c.packages, path)
+		}
+	}
+
+	for _, dir := range dirs {
+		// The roots of a bundle are owned by it, whether or not it contributes anything at them
+		roots, err := bundleRootRefs(dir)
+		if err != nil {
+			return c, fmt.Errorf("failed to read bundle manifest in %s: %w", dir, err)
+		}
+		c.data = append(c.data, roots...)
+
+		err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && path != dir && skip[filepath.Clean(path)] {
+				return filepath.SkipDir
+			}
+			if !entry.Type().IsRegular() || !isDataDocument(entry.Name()) {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			docPath := ast.DefaultRootRef.Copy()
+			if rel != "." {
+				for _, segment := range strings.Split(filepath.ToSlash(rel), "/") {
+					docPath = append(docPath, ast.StringTerm(segment))
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			var doc interface{}
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				printer.Debug("Skipping data document %s: %s", path, err)
+				return nil
+			}
+			if m, ok := doc.(map[string]interface{}); ok {
+				for key := range m {
+					c.data = append(c.data, docPath.Append(ast.StringTerm(key)))
+				}
+			} else if doc != nil {
+				c.data = append(c.data, docPath)
+			}
+			return nil
+		})
+		if err != nil {
+			return c, fmt.Errorf("failed to list data documents in %s: %w", dir, err)
+		}
+	}
+	sort.Slice(c.data, func(i, j int) bool {
+		return c.data[i].Compare(c.data[j]) < 0
+	})
+	return c, nil
+}
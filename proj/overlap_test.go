@@ -0,0 +1,129 @@
+package proj
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		note     string
+		files    map[string]string
+		expected []string
+	}{
+		{
+			note: "namespaced dependencies",
+			files: map[string]string{
+				"opa.project":     "source: src\ndependencies:\n  a: file:/a\n  b: file:/b\n",
+				"src/main.rego":   "package lib\n",
+				"a/policy.rego":   "package lib\n",
+				"b/policy.rego":   "package lib\n",
+				"b/lib/data.json": `{"users": []}`,
+			},
+		},
+		{
+			note: "same package",
+			files: map[string]string{
+				"opa.project":   "source: src\ndependencies:\n  a:\n    location: file:/a\n    namespace: false\n  b:\n    location: file:/b\n    namespace: false\n",
+				"src/main.rego": "package main\n",
+				"a/policy.rego": "package lib\n\na := true\n",
+				"b/policy.rego": "package lib\n\nb := true\n",
+			},
+			expected: []string{
+				"data.lib is contributed to by both dependency a and dependency b",
+			},
+		},
+		{
+			note: "project and transitive dependency",
+			files: map[string]string{
+				"opa.project":   "source: src\ndependencies:\n  a:\n    location: file:/a\n    namespace: false\n",
+				"src/main.rego": "package lib.x\n",
+				"a/opa.project": "dependencies:\n  b:\n    location: file:/b\n    namespace: false\n",
+				"a/policy.rego": "package a\n",
+				"b/policy.rego": "package lib.x\n",
+			},
+			expected: []string{
+				"data.lib.x is contributed to by both the project and dependency a > b",
+			},
+		},
+		{
+			note: "data under package",
+			files: map[string]string{
+				"opa.project":     "source: src\ndependencies:\n  a:\n    location: file:/a\n    namespace: false\n  b:\n    location: file:/b\n    namespace: false\n",
+				"src/main.rego":   "package main\n",
+				"a/policy.rego":   "package lib\n",
+				"b/lib/data.json": `{"users": [], "roles": {}}`,
+				"b/other.yaml":    "enabled: true\n",
+			},
+			expected: []string{
+				`data.lib.roles is contributed to by both dependency a and dependency b`,
+				`data.lib.users is contributed to by both dependency a and dependency b`,
+			},
+		},
+		{
+			note: "data documents",
+			files: map[string]string{
+				"opa.project":        "source: src\ndependencies:\n  a:\n    location: file:/a\n    namespace: false\n",
+				"src/data.json":      `{"config": {"debug": false}}`,
+				"a/config/data.yaml": "debug: true\n",
+			},
+			expected: []string{
+				"data.config.debug is contributed to by both the project and dependency a",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(tc.files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if len(tc.expected) == 0 {
+					if err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					if err == nil || !strings.HasPrefix(err.Error(), "dependencies overlap in data:") {
+						t.Fatalf("expected overlap error, got %v", err)
+					}
+					for _, expected := range tc.expected {
+						if !strings.Contains(err.Error(), expected) {
+							t.Fatalf("expected error containing '%s', got %v", expected, err)
+						}
+					}
+
+					project, err = ReadProjectFromFile(path, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := project.Update(UpdateOptions{AllowOverlap: true}); err != nil {
+						t.Fatalf("expected overlaps to be allowed, got %v", err)
+					}
+				}
+
+				loaded, err := ReadAndLoadProject(path, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				overlaps, err := loaded.Overlaps()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var actual []string
+				for _, o := range overlaps {
+					actual = append(actual, o.String())
+				}
+				if !reflect.DeepEqual(actual, tc.expected) {
+					t.Fatalf("expected overlaps:\n%v\ngot:\n%v", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,17 +1,22 @@
 package proj
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/johanfylling/odm/printer"
 	"github.com/johanfylling/odm/utils"
+	"github.com/open-policy-agent/opa/ast"
 	"gopkg.in/yaml.v3"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 )
 
 const (
@@ -25,17 +30,29 @@ type Project struct {
 	SourceDirs   []string     `yaml:"source,omitempty"`
 	TestDirs     []string     `yaml:"tests,omitempty"`
 	Dependencies Dependencies `yaml:"dependencies,omitempty"`
-	Build        Build        `yaml:"build,omitempty"`
-	filePath     string
+	Resolution   string       `yaml:"resolution,omitempty"`
+	// Registry is the location of the package registry dependencies declared by version are resolved against
+	Registry string `yaml:"registry,omitempty"`
+	Build    Build  `yaml:"build,omitempty"`
+	// Imports maps logical names, referred to in the project's sources, to the namespaces in data they stand for
+	Imports  map[string]string `yaml:"imports,omitempty"`
+	filePath string
+	// file is the content of the project file the project was read from, that mutations are applied to.
+	file *projectFile
+	// compiled maps the project's source and test locations to their copies written by CompileSources
+	compiled map[string]string
 }
 
 type ProjectSerialization struct {
-	Name         string       `yaml:"name,omitempty"`
-	Version      string       `yaml:"version,omitempty"`
-	Source       interface{}  `yaml:"source,omitempty"`
-	Test         interface{}  `yaml:"tests,omitempty"`
-	Dependencies Dependencies `yaml:"dependencies,omitempty"`
-	Build        Build        `yaml:"build,omitempty"`
+	Name         string            `yaml:"name,omitempty"`
+	Version      string            `yaml:"version,omitempty"`
+	Source       interface{}       `yaml:"source,omitempty"`
+	Test         interface{}       `yaml:"tests,omitempty"`
+	Dependencies Dependencies      `yaml:"dependencies,omitempty"`
+	Resolution   string            `yaml:"resolution,omitempty"`
+	Registry     string            `yaml:"registry,omitempty"`
+	Build        Build             `yaml:"build,omitempty"`
+	Imports      map[string]string `yaml:"imports,omitempty"`
 }
 
 type Build struct {
@@ -47,6 +64,13 @@ type Build struct {
 type DependencyInfo struct {
 	Location  string `yaml:"location"`
 	Namespace string `yaml:"namespace,omitempty"`
+	Version   string `yaml:"version,omitempty"`
+	Ref       string `yaml:"ref,omitempty"`
+	Tag       string `yaml:"tag,omitempty"`
+	Branch    string `yaml:"branch,omitempty"`
+	Commit    string `yaml:"commit,omitempty"`
+	Path      string `yaml:"path,omitempty"`
+	Sha256    string `yaml:"sha256,omitempty"`
 }
 
 type Dependency struct {
@@ -59,6 +83,133 @@ type Dependency struct {
 
 type Dependencies map[string]Dependency
 
+type UpdateOptions struct {
+	// Refresh re-resolves every dependency from its declared location, ignoring revisions recorded in the lock file.
+	Refresh bool
+	// Frozen requires the dependency tree to exactly match the lock file, which is left unchanged.
+	Frozen bool
+	// Offline resolves dependencies exclusively from the dependency cache, or from dependency directories already
+	// materialized by a previous update, without contacting any remote repository.
+	Offline bool
+	// Jobs is the maximum number of dependencies fetched and namespaced concurrently; the number of CPUs if zero.
+	Jobs int
+	// AllowOverlap warns, rather than fails, when the project and its dependencies contribute to the same paths in data.
+	AllowOverlap bool
+}
+
+type updater struct {
+	rootDir     string
+	depsRootDir string
+	opts        UpdateOptions
+	lock        *Lock
+	resolved    *Lock
+	// overrides maps library keys to the location selected for all dependencies on that library
+	overrides map[string]string
+	// registry is the package registry declared by the project
+	registry string
+	// verify only checks dependencies against the lock file, leaving dependency directories unchanged
+	verify bool
+	// previous holds the dependencies materialized by the previous resolution round, by dependency id; which are kept
+	// as they are, unless the location selected for their library has changed
+	previous map[string]materialization
+
+	// ctx is canceled when any dependency fails to update, stopping all other in-flight updates
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan struct{}
+	// depLocks holds a mutex per dependency id, as dependencies with the same id share a dependency directory
+	depLocks sync.Map
+
+	// mu guards resolved, nodes, and materialized
+	mu           sync.Mutex
+	nodes        []libraryNode
+	materialized map[string]materialization
+}
+
+// materialization is a dependency as materialized by an update, with the location selected for its library, if any.
+type materialization struct {
+	locked   LockedDependency
+	node     libraryNode
+	override string
+}
+
+func (u *updater) acquireJob() error {
+	select {
+	case u.jobs <- struct{}{}:
+		return nil
+	case <-u.ctx.Done():
+		return u.ctx.Err()
+	}
+}
+
+func (u *updater) releaseJob() {
+	<-u.jobs
+}
+
+func (u *updater) lockDependency(id string) func() {
+	m, _ := u.depLocks.LoadOrStore(id, &sync.Mutex{})
+	m.(*sync.Mutex).Lock()
+	return m.(*sync.Mutex).Unlock
+}
+
+// record adds a materialized dependency to the resolved lock, and to the dependency tree.
+func (u *updater) record(dep LockedDependency, node libraryNode) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.resolved.add(dep)
+	u.nodes = append(u.nodes, node)
+	if u.materialized == nil {
+		u.materialized = map[string]materialization{}
+	}
+	u.materialized[dep.id()] = materialization{locked: dep, node: node, override: u.overrides[node.key]}
+}
+
+// updateAll concurrently updates deps, and their transitive dependencies. If any dependency fails, all other updates
+// are canceled; the error of the first dependency, by name, that failed other than by being canceled is returned
+// together with its name.
+// The messages of every dependency, including those of its transitive dependencies, are printed together once it's
+// updated; in order of dependency name, regardless of which dependency is updated first.
+func (u *updater) updateAll(ctx context.Context, deps Dependencies, parent *Dependency) (string, error) {
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := make([]error, len(names))
+	bufs := make([]*printer.Buffer, len(names))
+	done := make([]chan struct{}, len(names))
+	for i, name := range names {
+		dep := deps[name]
+		dep.ParentDependency = parent
+		depCtx, buf := printer.WithBuffer(ctx)
+		bufs[i] = buf
+		done[i] = make(chan struct{})
+		go func(i int) {
+			defer close(done[i])
+			if errs[i] = dep.update(depCtx, u); errs[i] != nil {
+				u.cancel()
+			}
+		}(i)
+	}
+	for i := range names {
+		<-done[i]
+		bufs[i].Flush()
+	}
+
+	// Updates canceled because of another failure are only reported if there is no other error
+	failed := -1
+	for i, err := range errs {
+		if err != nil && (failed < 0 || errors.Is(errs[failed], context.Canceled) && !errors.Is(err, context.Canceled)) {
+			failed = i
+		}
+	}
+	if failed < 0 {
+		return "", nil
+	}
+	return names[failed], errs[failed]
+}
+
 func NewProject(path string) *Project {
 	return &Project{
 		Dependencies: make(map[string]Dependency),
@@ -71,16 +222,16 @@ func (ds *Dependencies) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
+	nodes := make(map[string]yaml.Node)
+	if err := unmarshal(&nodes); err != nil {
+		return err
+	}
 
 	*ds = make(map[string]Dependency)
 	for k, v := range raw {
 		var info DependencyInfo
+		node := nodes[k]
 		switch v.(type) {
-		case string:
-			info = DependencyInfo{
-				Location:  v.(string),
-				Namespace: k,
-			}
 		case map[string]interface{}:
 			var namespace = ""
 			if ns := v.(map[string]interface{})["namespace"]; ns != nil {
@@ -98,10 +249,28 @@ func (ds *Dependencies) UnmarshalYAML(unmarshal func(interface{}) error) error {
 				// If no namespace is specified, default to the dependency name
 				namespace = k
 			}
+			location, _ := v.(map[string]interface{})["location"].(string)
+			if location == "" {
+				// Dependencies without a location are resolved against the registry, by their name
+				location = registryPrefix + k
+			}
 			info = DependencyInfo{
-				Location:  v.(map[string]interface{})["location"].(string),
+				Location:  location,
 				Namespace: namespace,
+				Version:   stringAttribute(&node, "version"),
+				Ref:       stringAttribute(&node, "ref"),
+				Tag:       stringAttribute(&node, "tag"),
+				Branch:    stringAttribute(&node, "branch"),
+				Commit:    stringAttribute(&node, "commit"),
+				Path:      stringAttribute(&node, "path"),
+				Sha256:    stringAttribute(&node, "sha256"),
+			}
+		default:
+			if node.Kind != yaml.ScalarNode {
+				return fmt.Errorf("invalid dependency %s: expected a location, version, or mapping", k)
 			}
+			// Read as written, as versions such as '1.2' are parsed as numbers
+			info = ShortFormDependency(k, node.Value)
 		}
 		(*ds)[k] = Dependency{
 			DependencyInfo: info,
@@ -112,10 +281,21 @@ func (ds *Dependencies) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (ds *Dependencies) MarshalYAML() (interface{}, error) {
+// stringAttribute returns the value of the key attribute of a mapping node, as written.
+// Unquoted values, such as the version '1.2' or the commit SHA '5017e33', which YAML parses as numbers, are kept intact.
+func stringAttribute(node *yaml.Node, key string) string {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k, v := node.Content[i], node.Content[i+1]; k.Value == key && v.Kind == yaml.ScalarNode && v.Tag != "!!null" {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+func (ds Dependencies) MarshalYAML() (interface{}, error) {
 	depMap := make(map[string]Dependency)
-	for _, dep := range *ds {
-		depMap[dep.Location] = dep
+	for name, dep := range ds {
+		depMap[name] = dep
 	}
 
 	return depMap, nil
@@ -124,26 +304,50 @@ func (ds *Dependencies) MarshalYAML() (interface{}, error) {
 func (d Dependency) MarshalYAML() (interface{}, error) {
 	printer.Debug("Marshalling dependency %s", d.Name)
 
-	if d.Namespace == d.Name {
+	if d.Namespace == d.Name && d.requested() == "" && d.Path == "" && d.Sha256 == "" {
 		return d.Location, nil
 	}
+	if d.Namespace == d.Name && d.Location == registryPrefix+d.Name && d.requested() == "version "+d.Version {
+		return d.Version, nil
+	}
+
+	m := map[string]interface{}{
+		"location": d.Location,
+	}
 
 	if d.Namespace == "" {
-		return map[string]interface{}{
-			"namespace": false,
-			"location":  d.Location,
-		}, nil
+		m["namespace"] = false
+	} else if d.Namespace != d.Name {
+		m["namespace"] = d.Namespace
+	}
 
+	for k, v := range map[string]string{
+		"version": d.Version,
+		"ref":     d.Ref,
+		"tag":     d.Tag,
+		"branch":  d.Branch,
+		"commit":  d.Commit,
+		"path":    d.Path,
+		"sha256":  d.Sha256,
+	} {
+		if v != "" {
+			m[k] = v
+		}
 	}
 
-	return map[string]interface{}{
-		"namespace": d.Namespace,
-		"location":  d.Location,
-	}, nil
+	return m, nil
 }
 
 func (d Dependency) id() string {
-	return DepId(d.fullNamespace(), d.Location)
+	return DepId(d.fullNamespace(), depSource(d.Location, d.Path))
+}
+
+// depSource identifies the source of a dependency at location, including the path attribute of git dependencies.
+func depSource(location, path string) string {
+	if path == "" {
+		return location
+	}
+	return fmt.Sprintf("%s path %s", location, path)
 }
 
 func DepId(namespace, location string) string {
@@ -157,71 +361,349 @@ func (d Dependency) dir(rootDir string) string {
 	return filepath.Join(rootDir, d.id())
 }
 
-func (d Dependency) Update(rootDir, depsRootDir string) error {
-	targetDir := d.dir(depsRootDir)
-
-	if err := os.RemoveAll(targetDir); err != nil {
+func (d Dependency) update(ctx context.Context, u *updater) error {
+	if err := d.materialize(ctx, u); err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
+	if err := d.updateTransitive(ctx, u); err != nil {
+		return fmt.Errorf("failed to update transitive dependencies for %s: %w", d.Namespace, err)
+	}
+	return nil
+}
+
+// materialize fetches and namespaces the dependency into its dependency directory, unless the directory is still
+// valid from a previous update, and records it in the updater.
+// Dependencies sharing a directory are materialized one at a time; and at most as many dependencies as the updater
+// has jobs are materialized concurrently.
+func (d *Dependency) materialize(ctx context.Context, u *updater) error {
+	log := printer.From(ctx)
+	unlock := u.lockDependency(d.id())
+	defer unlock()
+
+	targetDir := d.dir(u.depsRootDir)
+
+	// A dependency materialized by the previous resolution round is only fetched again if its location has changed
+	if prev, ok := u.previous[d.id()]; ok && prev.override == u.overrides[d.libraryKey(u.rootDir)] {
+		d.Project = prev.node.dep.Project
+		d.dirPath = targetDir
+		node := prev.node
+		node.dep = *d
+		u.record(prev.locked, node)
+		return nil
+	}
+
+	if err := u.acquireJob(); err != nil {
 		return err
 	}
+	defer u.releaseJob()
 
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory %s: %w", targetDir, err)
+	var locked *LockedDependency
+	if !u.opts.Refresh {
+		locked = u.lock.find(d.id())
+	}
+	if u.opts.Frozen && locked == nil {
+		return fmt.Errorf("dependency %s (%s) is missing from lock file", d.Name, d.Location)
+	}
+	if locked != nil && locked.Requested != d.requested() {
+		if u.opts.Frozen {
+			return fmt.Errorf("requested ref of dependency %s (%s) has changed since it was locked", d.Name, d.Location)
+		}
+		locked = nil
 	}
 
-	if strings.HasPrefix(d.Location, "git+") {
-		printer.Debug("Updating git dependency %s", d.Namespace)
-		if err := d.updateGit(targetDir); err != nil {
+	// The location actually fetched differs from the declared one when a single version has been selected for
+	// all dependencies on the same library
+	resolvedLocation := u.overrides[d.libraryKey(u.rootDir)]
+	if resolvedLocation == d.Location {
+		resolvedLocation = ""
+	}
+	if locked != nil && resolvedLocation != "" && locked.Resolved != resolvedLocation {
+		locked = nil
+	}
+	if locked != nil && locked.Resolved != "" {
+		resolvedLocation = locked.Resolved
+	}
+	fetched := *d
+	if resolvedLocation != "" {
+		log.Debug("Using location %s for dependency %s", resolvedLocation, d.Name)
+		fetched.DependencyInfo = DependencyInfo{
+			Location:  resolvedLocation,
+			Namespace: d.Namespace,
+		}
+	}
+
+	fetchErr := func(err error) error {
+		if u.opts.Offline {
+			return fmt.Errorf("dependency %s (%s) is not available offline: %w", d.Name, d.Location, err)
+		}
+		return err
+	}
+
+	var src gitSource
+	var revision string
+	isGit := strings.HasPrefix(fetched.Location, "git+")
+	isArchive := isArchiveLocation(fetched.Location)
+	isOci := isOciLocation(fetched.Location)
+	isRegistry := isRegistryLocation(fetched.Location)
+	var registry *packageRegistry
+	var pkgVersion string
+	if fetched.Sha256 != "" && !isArchive {
+		return fmt.Errorf("checksums are only supported for archive dependencies: %s", d.Location)
+	}
+	if isGit {
+		log.Debug("Updating git dependency %s", d.Namespace)
+		var err error
+		if src, err = fetched.resolveGit(ctx, locked, u.opts.Offline); err != nil {
+			return fetchErr(err)
+		}
+		if !src.hash.IsZero() {
+			revision = src.hash.String()
+		}
+	} else if isRegistry {
+		if fetched.Ref != "" || fetched.Tag != "" || fetched.Branch != "" || fetched.Commit != "" || fetched.Path != "" {
+			return fmt.Errorf("only version constraints are supported for registry dependencies: %s", d.Location)
+		}
+		var err error
+		if registry, err = d.registry(u); err != nil {
 			return err
 		}
-	} else if strings.HasPrefix(d.Location, "file:") {
-		printer.Debug("Updating git dependency %s", d.Namespace)
-		printer.Debug("Updating transitive dependencies for %s", d.Namespace)
-		if err := d.updateLocal(rootDir, targetDir); err != nil {
+		if _, pkgVersion, err = fetched.registryPackage(); err != nil {
 			return err
 		}
-	} else {
+		// Packages are pinned at the version, and archive checksum, recorded in the lock file
+		if locked != nil && locked.RefType == RefTypeVersion && (pkgVersion == "" || pkgVersion == locked.Ref) {
+			pkgVersion = locked.Ref
+			revision = locked.Revision
+		}
+	} else if fetched.requested() != "" {
+		return fmt.Errorf("refs and version constraints are only supported for git dependencies: %s", d.Location)
+	} else if fetched.Path != "" {
+		return fmt.Errorf("paths are only supported for git dependencies: %s", d.Location)
+	} else if isArchive {
+		// Archives are identified by their checksum; the declared one, or the one recorded in the lock file, unless
+		// read from a local file that may have changed since
+		var err error
+		if revision, err = fetched.archiveChecksum(); err != nil {
+			return err
+		}
+		if revision == "" && locked != nil && isHttpLocation(strings.TrimPrefix(fetched.Location, bundlePrefix)) {
+			revision = locked.Revision
+		}
+	} else if isOci {
+		// OCI artifacts are identified by the digest of their manifest; the one in the location, or the one recorded in
+		// the lock file
+		var err error
+		if revision, err = fetched.ociDigest(); err != nil {
+			return err
+		}
+		if revision == "" && locked != nil {
+			revision = locked.Revision
+		}
+	} else if !strings.HasPrefix(fetched.Location, "file:") {
 		return fmt.Errorf("unsupported dependency location: %s", d.Location)
 	}
 
-	depProjectFile := fmt.Sprintf("%s/opa.project", targetDir)
+	// A dependency directory materialized by a previous update is kept, without fetching or namespacing the dependency
+	// again, if it was materialized from the same location and revision, and hasn't been modified since
+	namespace := d.fullNamespace()
+	state := readDependencyState(ctx, u.depsRootDir, d.id())
+	var digest string
+	var kept bool
+	var contentDir string
+	if revision != "" && state.keeps(ctx, targetDir, fetched.Location, fetched.Path, namespace, revision) {
+		log.Debug("Dependency %s is up to date", d.Name)
+		digest = state.Digest
+		kept = true
+	} else {
+		if isGit {
+			treeDir, hash, err := src.fetchTree(ctx, u.opts.Offline)
+			if err != nil {
+				return fetchErr(err)
+			}
+			revision = hash.String()
+			contentDir = treeDir
+		} else if isArchive {
+			log.Debug("Updating archive dependency %s", d.Namespace)
+			archiveDir, sum, err := fetched.fetchArchive(ctx, u.rootDir, revision, u.opts.Offline)
+			if err != nil {
+				return fetchErr(err)
+			}
+			revision = sum
+			contentDir = archiveDir
+		} else if isOci {
+			log.Debug("Updating OCI dependency %s", d.Namespace)
+			ociDir, manifestDigest, err := fetched.fetchOci(ctx, revision, u.opts.Offline)
+			if err != nil {
+				return fetchErr(err)
+			}
+			revision = manifestDigest
+			contentDir = ociDir
+		} else if isRegistry {
+			log.Debug("Updating registry dependency %s", d.Namespace)
+			pkgDir, version, sum, err := fetched.fetchPackage(ctx, registry, pkgVersion, revision, u.opts.Offline)
+			if err != nil {
+				return fetchErr(err)
+			}
+			pkgVersion = version
+			revision = sum
+			contentDir = pkgDir
+		} else {
+			log.Debug("Updating local dependency %s", d.Namespace)
+			// When verifying, the dependency directory might not exist yet, and must not be created
+			stagingRoot := u.depsRootDir
+			if u.verify {
+				stagingRoot = ""
+			}
+			stagingDir, err := os.MkdirTemp(stagingRoot, ".staging-")
+			if err != nil {
+				return fmt.Errorf("failed to create staging directory: %w", err)
+			}
+			defer func() {
+				_ = os.RemoveAll(stagingDir)
+			}()
+			if err := fetched.updateLocal(ctx, u.rootDir, stagingDir); err != nil {
+				return err
+			}
+			contentDir = stagingDir
+		}
+
+		var err error
+		if digest, err = utils.HashDir(contentDir, []string{".git"}); err != nil {
+			return fmt.Errorf("failed to compute digest for %s: %w", d.Name, err)
+		}
+		if revision == "" {
+			// Local dependencies have no revision of their own; they are identified by the hash of their file tree
+			revision = digest
+		}
+
+		if state.keeps(ctx, targetDir, fetched.Location, fetched.Path, namespace, revision) {
+			log.Debug("Dependency %s is up to date", d.Name)
+			kept = true
+		}
+	}
+
+	// The content is checked against the lock file before the dependency directory is touched
+	if locked != nil && locked.Digest != digest {
+		if u.opts.Frozen {
+			return fmt.Errorf("content of dependency %s (%s) does not match lock file; expected digest %s, got %s",
+				d.Name, d.Location, locked.Digest, digest)
+		}
+		log.Info("Dependency %s has changed since it was locked", d.Name)
+	}
+
+	if !kept && !u.verify {
+		if err := removeDependencyState(u.depsRootDir, d.id()); err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(targetDir); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(targetDir, 0755); err != nil {
+			return fmt.Errorf("failed to create destination directory %s: %w", targetDir, err)
+		}
+
+		if err := utils.CopyAll(ctx, contentDir, targetDir, nil); err != nil {
+			return err
+		}
+	}
+
+	ref := src.ref
+	if isRegistry {
+		ref = gitRef{typ: RefTypeVersion, name: pkgVersion}
+	}
+	resolved := LockedDependency{
+		Name:      d.Name,
+		Location:  d.Location,
+		Path:      d.Path,
+		Namespace: d.fullNamespace(),
+		Requested: d.requested(),
+		Resolved:  resolvedLocation,
+		Ref:       ref.name,
+		RefType:   ref.typ,
+		Revision:  revision,
+		Digest:    digest,
+	}
+
+	// When verifying, a dependency that isn't kept is read from where it was fetched, as its directory is left as is
+	projectDir := targetDir
+	if u.verify && !kept {
+		projectDir = contentDir
+	}
+	depProjectFile := fmt.Sprintf("%s/opa.project", projectDir)
 	if utils.FileExists(depProjectFile) {
 		var err error
 		d.Project, err = ReadProjectFromFile(depProjectFile, false)
 		if err != nil {
 			return err
 		}
+		// The project is still located at the dependency directory, for paths declared in it to be resolved against
+		d.Project.filePath = normalizeProjectPath(targetDir)
 	}
 	d.dirPath = targetDir
+	u.record(resolved, newLibraryNode(*d, fetched, u.rootDir, ref, digest))
 
-	if err := d.updateTransitive(rootDir, depsRootDir); err != nil {
-		return fmt.Errorf("failed to update transitive dependencies for %s: %w", d.Namespace, err)
+	if kept || u.verify {
+		return nil
 	}
 
-	if namespace := d.fullNamespace(); namespace != "" {
-		var dirs []string
-		if srcDirs := d.SourceDirs(); len(srcDirs) > 0 {
-			dirs = append(dirs, srcDirs...)
-		} else {
-			dirs = append(dirs, targetDir)
+	// OCI artifacts commonly hold OPA bundles, as OPA itself pulls them from registries; and are prepared as such if
+	// they have a manifest
+	if isBundleLocation(fetched.Location) || (isOci && utils.FileExists(filepath.Join(targetDir, bundleManifestFile))) {
+		if err := prepareBundle(ctx, targetDir, namespace); err != nil {
+			return fmt.Errorf("invalid bundle dependency %s: %w", d.Name, err)
+		}
+	}
+
+	var dirs []string
+	if srcDirs := d.SourceDirs(); len(srcDirs) > 0 {
+		dirs = append(dirs, srcDirs...)
+	} else {
+		dirs = append(dirs, targetDir)
+	}
+	dirs = append(dirs, d.TestDirs()...)
+	dirs = utils.FilterExistingFiles(dirs)
+
+	// The dependency's own imports are resolved before it's namespaced, as they refer to data as seen by the dependency
+	if d.Project != nil && len(d.Project.Imports) > 0 && len(dirs) > 0 {
+		aliases, err := parseImports(d.Project.Imports)
+		if err != nil {
+			return fmt.Errorf("invalid imports of dependency %s: %w", d.Name, err)
+		}
+		if err := importModules(ctx, targetDir, dirs, aliases); err != nil {
+			return fmt.Errorf("failed to resolve imports of dependency %s: %w", d.Name, err)
 		}
-		dirs = append(dirs, d.TestDirs()...)
-		dirs = utils.FilterExistingFiles(dirs)
+	}
 
+	if namespace != "" {
 		if len(dirs) > 0 {
-			opa := utils.NewOpa(dirs...)
-			if err := opa.Refactor("data", fmt.Sprintf("data.%s", namespace)); err != nil {
-				return fmt.Errorf("failed to refactor namespace %s: %w", d.Namespace, err)
+			if err := namespaceModules(ctx, targetDir, dirs, namespace); err != nil {
+				return fmt.Errorf("failed to namespace dependency %s: %w", d.Name, err)
+			}
+			if err := namespaceDataDocuments(ctx, dirs, namespace); err != nil {
+				return fmt.Errorf("failed to namespace data of dependency %s: %w", d.Name, err)
 			}
 		} else {
-			printer.Debug("Dependency %s has no source, skipping namespace refactoring", d.Name)
+			log.Debug("Dependency %s has no source, skipping namespace refactoring", d.Name)
 		}
 	}
 
-	return nil
+	materialized, err := utils.HashDir(targetDir, nil)
+	if err != nil {
+		return fmt.Errorf("failed to compute digest for %s: %w", d.Name, err)
+	}
+	return writeDependencyState(u.depsRootDir, d.id(), dependencyState{
+		Location:     fetched.Location,
+		Path:         fetched.Path,
+		Namespace:    namespace,
+		Ref:          ref.name,
+		RefType:      ref.typ,
+		Revision:     revision,
+		Digest:       digest,
+		Materialized: materialized,
+	})
 }
 
 func (d Dependency) Load(rootDir, targetDir string) (*Dependency, error) {
@@ -241,16 +723,12 @@ func (d Dependency) Load(rootDir, targetDir string) (*Dependency, error) {
 	return &d, nil
 }
 
-func (d Dependency) updateLocal(rootDir, targetDir string) error {
-	sourceLocation, err := utils.NormalizeFilePath(d.Location)
+func (d Dependency) updateLocal(ctx context.Context, rootDir, targetDir string) error {
+	sourceLocation, err := d.localSource(rootDir)
 	if err != nil {
 		return err
 	}
 
-	if !filepath.IsAbs(sourceLocation) {
-		sourceLocation = filepath.Join(rootDir, sourceLocation)
-	}
-
 	if !utils.FileExists(sourceLocation) {
 		return fmt.Errorf("dependency %s does not exist", sourceLocation)
 	}
@@ -259,58 +737,23 @@ func (d Dependency) updateLocal(rootDir, targetDir string) error {
 		sourceLocation = utils.GetParentDir(sourceLocation)
 	}
 
-	// Ignore empty files, as an empty module will break the 'opa refactor' command
-	if err := utils.CopyAll(sourceLocation, targetDir, []string{".opa"}, true); err != nil {
+	if err := utils.CopyAll(ctx, sourceLocation, targetDir, []string{".opa"}); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (d Dependency) updateGit(targetDir string) error {
-	url, tag, err := parseGitUrl(d.Location)
-	if err != nil {
-		return err
-	}
-
-	repo, err := git.PlainClone(targetDir, false, &git.CloneOptions{
-		URL:      url,
-		Progress: printer.DebugPrinter(),
-	})
+// localSource returns the path of a local dependency; relative to rootDir, unless absolute.
+func (d Dependency) localSource(rootDir string) (string, error) {
+	sourceLocation, err := utils.NormalizeFilePath(d.Location)
 	if err != nil {
-		return fmt.Errorf("failed to clone git repository %s: %w", url, err)
-	}
-
-	if tag != "" {
-		w, err := repo.Worktree()
-		if err != nil {
-			return fmt.Errorf("failed to get worktree for git repository %s: %w", url, err)
-		}
-
-		if err := w.Checkout(&git.CheckoutOptions{
-			Branch: plumbing.NewTagReferenceName(tag),
-		}); err != nil {
-			return fmt.Errorf("failed to checkout tag '%s' for git repository %s: %w", tag, url, err)
-		}
-	} else {
-		printer.Debug("No tag specified, using HEAD")
-	}
-
-	return nil
-}
-
-func parseGitUrl(fullUrl string) (url string, tag string, err error) {
-	trimmedUrl := strings.TrimPrefix(fullUrl, "git+")
-	parts := strings.Split(trimmedUrl, "#")
-	if len(parts) > 2 {
-		return "", "", fmt.Errorf("invalid git url %s; only one tag separator '#' allowed", fullUrl)
+		return "", err
 	}
-
-	url = parts[0]
-	if len(parts) == 2 {
-		tag = parts[1]
+	if !filepath.IsAbs(sourceLocation) {
+		sourceLocation = filepath.Join(rootDir, sourceLocation)
 	}
-	return
+	return sourceLocation, nil
 }
 
 func (d Dependency) loadTransitive(rootDir, targetDir string) error {
@@ -330,16 +773,12 @@ func (d Dependency) loadTransitive(rootDir, targetDir string) error {
 	return nil
 }
 
-func (d Dependency) updateTransitive(rootDir, targetDir string) error {
-	printer.Debug("Updating transitive dependencies for %s (%s)", d.Namespace, d.id())
+func (d Dependency) updateTransitive(ctx context.Context, u *updater) error {
+	printer.From(ctx).Debug("Updating transitive dependencies for %s (%s)", d.Namespace, d.id())
 
 	if d.Project != nil {
-		for name, dep := range d.Project.Dependencies {
-			dep.ParentDependency = &d
-			if err := dep.Update(rootDir, targetDir); err != nil {
-				return err
-			}
-			d.Project.Dependencies[name] = dep
+		if _, err := u.updateAll(ctx, d.Project.Dependencies, &d); err != nil {
+			return err
 		}
 	}
 
@@ -389,7 +828,10 @@ func (p *Project) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	p.Name = raw.Name
 	p.Version = raw.Version
 	p.Dependencies = raw.Dependencies
+	p.Resolution = raw.Resolution
+	p.Registry = raw.Registry
 	p.Build = raw.Build
+	p.Imports = raw.Imports
 
 	var err error
 	p.SourceDirs, err = unmarshalDirs(raw.Source)
@@ -410,7 +852,10 @@ func (p Project) MarshalYAML() (interface{}, error) {
 	raw.Name = p.Name
 	raw.Version = p.Version
 	raw.Dependencies = p.Dependencies
+	raw.Resolution = p.Resolution
+	raw.Registry = p.Registry
 	raw.Build = p.Build
+	raw.Imports = p.Imports
 	if len(p.SourceDirs) == 1 {
 		raw.Source = p.SourceDirs[0]
 	} else if len(p.SourceDirs) > 1 {
@@ -446,14 +891,155 @@ func unmarshalDirs(raw interface{}) ([]string, error) {
 	}
 }
 
-func (p *Project) SetDependency(name string, info DependencyInfo) {
+// SetDependency declares the dependency name in the project, replacing any previous declaration.
+func (p *Project) SetDependency(name string, info DependencyInfo) error {
+	dep := Dependency{
+		DependencyInfo: info,
+		Name:           name,
+	}
+	if p.file != nil {
+		if err := p.file.setDependency(dep); err != nil {
+			return fmt.Errorf("failed to set dependency %s: %w", name, err)
+		}
+	}
 	if p.Dependencies == nil {
 		p.Dependencies = make(map[string]Dependency)
 	}
-	p.Dependencies[name] = Dependency{
-		DependencyInfo: info,
-		Name:           name,
+	p.Dependencies[name] = dep
+	return nil
+}
+
+// RemoveDependency removes the dependency name from the project, and returns it.
+func (p *Project) RemoveDependency(name string) (Dependency, error) {
+	dep, ok := p.Dependencies[name]
+	if !ok {
+		return Dependency{}, fmt.Errorf("dependency %s not found in project", name)
+	}
+	if p.file != nil {
+		if err := p.file.removeDependency(name); err != nil {
+			return Dependency{}, fmt.Errorf("failed to remove dependency %s: %w", name, err)
+		}
+	}
+	delete(p.Dependencies, name)
+	return dep, nil
+}
+
+// Prune removes the dependency directories, and lock file entries, of all dependencies that are no longer reachable
+// from the project, as materialized by the last update.
+func (p *Project) Prune() error {
+	if err := p.Load(); err != nil {
+		return err
+	}
+
+	ids := map[string]bool{}
+	if err := WalkDependencies(p, func(dep Dependency) error {
+		ids[dep.id()] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := pruneDependencies(dependenciesDir(p.Dir()), ids); err != nil {
+		return err
+	}
+
+	if !utils.FileExists(p.LockFilePath()) {
+		return nil
+	}
+	lock, err := ReadLockFromFile(p.LockFilePath())
+	if err != nil {
+		return err
+	}
+	pruned := &Lock{}
+	for _, dep := range lock.Dependencies {
+		if ids[dep.id()] {
+			pruned.add(dep)
+		} else {
+			printer.Debug("Removing dependency %s (%s) from lock file", dep.Name, dep.Location)
+		}
+	}
+	return pruned.WriteToFile(p.LockFilePath())
+}
+
+// FindImports returns the positions, as file:line, of all imports of and other references to the namespace, or any
+// package within it, in the Rego files of the project's source directories. Files that can't be parsed are skipped.
+func (p *Project) FindImports(namespace string) ([]string, error) {
+	target, err := ast.ParseRef(fmt.Sprintf("data.%s", namespace))
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace %s: %w", namespace, err)
 	}
+
+	var dirs []string
+	for _, dir := range p.SourceDirs {
+		dir, err := utils.NormalizeFilePath(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, filepath.Join(p.Dir(), dir))
+	}
+	if len(dirs) == 0 {
+		dirs = append(dirs, p.Dir())
+	}
+
+	var imports []string
+	for _, dir := range utils.FilterExistingFiles(dirs) {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if entry.Name() == dotOpaDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(path) != ".rego" {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			module, err := ast.ParseModule(path, string(data))
+			if err != nil || module == nil {
+				printer.Debug("Skipping %s, which can't be parsed: %v", path, err)
+				return nil
+			}
+
+			// The package declaration isn't a reference to the namespace
+			rows := map[int]bool{}
+			visit := func(ref ast.Ref) bool {
+				if ref.HasPrefix(target) && ref[0].Location != nil {
+					rows[ref[0].Location.Row] = true
+				}
+				return false
+			}
+			for _, imp := range module.Imports {
+				ast.WalkRefs(imp, visit)
+			}
+			for _, rule := range module.Rules {
+				ast.WalkRefs(rule, visit)
+			}
+			for _, row := range sortedRows(rows) {
+				imports = append(imports, fmt.Sprintf("%s:%d", path, row))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search for imports of %s: %w", namespace, err)
+		}
+	}
+	return imports, nil
+}
+
+func sortedRows(rows map[int]bool) []int {
+	sorted := make([]int, 0, len(rows))
+	for row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Ints(sorted)
+	return sorted
 }
 
 func ReadProjectFromFile(path string, allowMissing bool) (*Project, error) {
@@ -479,6 +1065,9 @@ func ReadProjectFromFile(path string, allowMissing bool) (*Project, error) {
 	}
 
 	project.filePath = path
+	if project.file, err = parseProjectFile(data); err != nil {
+		return nil, fmt.Errorf("failed to parse project file %s: %w", path, err)
+	}
 
 	return &project, nil
 }
@@ -496,21 +1085,149 @@ func ReadAndLoadProject(path string, allowMissing bool) (*Project, error) {
 	return project, nil
 }
 
-func (p *Project) Update() error {
+// Update fetches all dependencies of the project, including transitive dependencies, and records their resolved
+// state in the project's lock file.
+// Unless opts.Refresh is set, dependencies present in an existing lock file are reproduced at their locked revision.
+// If opts.Frozen is set, the update fails if the dependency tree doesn't exactly match the lock file; which is verified
+// before any dependency directory is changed.
+func (p *Project) Update(opts UpdateOptions) error {
+	if opts.Frozen && opts.Refresh {
+		return fmt.Errorf("a frozen update cannot refresh dependencies")
+	}
+	if opts.Jobs < 0 {
+		return fmt.Errorf("invalid number of jobs %d; must be at least 1", opts.Jobs)
+	}
+
+	var deduplicate bool
+	switch p.Resolution {
+	case "", ResolutionIsolated:
+	case ResolutionDeduplicate:
+		deduplicate = true
+	default:
+		return fmt.Errorf("unsupported resolution mode '%s'", p.Resolution)
+	}
+
 	rootDir := filepath.Dir(p.filePath)
-	return p.update(rootDir)
-}
+	depsRootDir := dependenciesDir(rootDir)
 
-func (p *Project) update(rootDir string) error {
-	depRootDir := dependenciesDir(rootDir)
+	lock, err := ReadLockFromFile(p.LockFilePath())
+	if err != nil {
+		return err
+	}
 
-	for name, dep := range p.Dependencies {
-		if err := dep.Update(rootDir, depRootDir); err != nil {
-			return fmt.Errorf("failed to update dependency %s: %w", name, err)
+	jobs := opts.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updateAll := func(verify bool) (*updater, error) {
+		var u *updater
+		overrides := map[string]string{}
+		var previous map[string]materialization
+		for round := 1; ; round++ {
+			u = &updater{
+				rootDir:     rootDir,
+				depsRootDir: depsRootDir,
+				opts:        opts,
+				lock:        lock,
+				resolved:    &Lock{},
+				overrides:   overrides,
+				registry:    p.Registry,
+				verify:      verify,
+				previous:    previous,
+				ctx:         ctx,
+				cancel:      cancel,
+				jobs:        make(chan struct{}, jobs),
+			}
+
+			if err := p.update(u); err != nil {
+				return nil, err
+			}
+			// Dependencies are updated concurrently, so the tree is ordered for library selection to be deterministic
+			sort.SliceStable(u.nodes, func(i, j int) bool {
+				return u.nodes[i].dep.path() < u.nodes[j].dep.path()
+			})
+
+			if !deduplicate {
+				return u, nil
+			}
+
+			selected, changed, err := selectLibraryLocations(ctx, u.nodes, overrides, opts.Offline)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				return u, nil
+			}
+			if opts.Frozen {
+				return nil, fmt.Errorf("lock file doesn't select a single version for every library in the dependency tree")
+			}
+			if round == maxResolutionRounds {
+				return nil, fmt.Errorf("failed to select dependency versions after %d rounds", round)
+			}
+			printer.Debug("Selected library versions changed, updating dependencies again")
+			overrides = selected
+			previous = u.materialized
 		}
-		p.Dependencies[name] = dep
 	}
 
+	// A frozen update verifies the whole dependency tree against the lock file before any dependency directory is
+	// written to, or pruned
+	if opts.Frozen {
+		u, err := updateAll(true)
+		if err != nil {
+			return err
+		}
+		for _, locked := range lock.Dependencies {
+			if u.resolved.find(locked.id()) == nil {
+				return fmt.Errorf("lock file contains dependency %s (%s), which is no longer declared", locked.Name, locked.Location)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(depsRootDir, 0755); err != nil {
+		return fmt.Errorf("failed to create dependency directory %s: %w", depsRootDir, err)
+	}
+	u, err := updateAll(false)
+	if err != nil {
+		return err
+	}
+
+	// Remove dependency directories left behind by dependencies that are no longer declared
+	ids := map[string]bool{}
+	for _, dep := range u.resolved.Dependencies {
+		ids[dep.id()] = true
+	}
+	if err := pruneDependencies(depsRootDir, ids); err != nil {
+		return err
+	}
+
+	deps := make([]Dependency, 0, len(u.nodes))
+	for _, n := range u.nodes {
+		deps = append(deps, n.dep)
+	}
+	overlaps, err := p.overlaps(deps)
+	if err != nil {
+		return err
+	}
+	if err := checkOverlaps(overlaps, opts.AllowOverlap); err != nil {
+		return err
+	}
+
+	if opts.Frozen {
+		return nil
+	}
+
+	return u.resolved.WriteToFile(p.LockFilePath())
+}
+
+func (p *Project) update(u *updater) error {
+	if name, err := u.updateAll(u.ctx, p.Dependencies, nil); err != nil {
+		return fmt.Errorf("failed to update dependency %s: %w", name, err)
+	}
 	return nil
 }
 
@@ -541,21 +1258,13 @@ func (p *Project) load(rootDir string) error {
 }
 
 func (p *Project) DataLocations() ([]string, error) {
-	var dataLocations []string
-	projDir := filepath.Dir(p.filePath)
-	if len(p.SourceDirs) > 0 {
-		for _, dir := range p.SourceDirs {
-			if dir, err := utils.NormalizeFilePath(dir); err != nil {
-				return nil, err
-			} else {
-				dataLocations = append(dataLocations, filepath.Join(projDir, dir))
-			}
-		}
-	} else {
-		dataLocations = append(dataLocations, projDir)
+	dataLocations, err := p.sourceLocations()
+	if err != nil {
+		return nil, err
 	}
+	dataLocations = p.compiledLocations(dataLocations)
 
-	err := WalkDependencies(p, func(dep Dependency) error {
+	err = WalkDependencies(p, func(dep Dependency) error {
 		dataLocations = append(dataLocations, dep.SourceDirs()...)
 		return nil
 	})
@@ -580,6 +1289,7 @@ func (p *Project) TestLocations(includeDependencies bool) ([]string, error) {
 			}
 		}
 	}
+	testLocations = p.compiledLocations(testLocations)
 
 	if includeDependencies {
 		err := WalkDependencies(p, func(dep Dependency) error {
@@ -594,6 +1304,8 @@ func (p *Project) TestLocations(includeDependencies bool) ([]string, error) {
 	return testLocations, nil
 }
 
+// WriteToFile writes the project to the project file at path. A project read from a file is written with the content
+// of that file, as edited by mutations of the project, so that its comments and ordering are preserved.
 func (p *Project) WriteToFile(path string, override bool) error {
 	path = normalizeProjectPath(path)
 	printer.Debug("Writing project file to %s", path)
@@ -602,12 +1314,17 @@ func (p *Project) WriteToFile(path string, override bool) error {
 		return fmt.Errorf("project file %s already exists", path)
 	}
 
-	data, err := yaml.Marshal(p)
-	if err != nil {
-		return fmt.Errorf("failed to marshal project file %s: %w", path, err)
+	var data []byte
+	if p.file != nil {
+		data = p.file.data
+	} else {
+		var err error
+		if data, err = yaml.Marshal(p); err != nil {
+			return fmt.Errorf("failed to marshal project file %s: %w", path, err)
+		}
 	}
 
-	err = os.WriteFile(path, data, 0644)
+	err := os.WriteFile(path, data, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write project file %s: %w", path, err)
 	}
@@ -615,41 +1332,27 @@ func (p *Project) WriteToFile(path string, override bool) error {
 	return nil
 }
 
+// PrintTree writes the dependency tree of the loaded project to w, as indented text.
 func (p *Project) PrintTree(w io.Writer) error {
-	if err := p.printTree(w, "root", 0); err != nil {
+	tree, err := p.Tree(0)
+	if err != nil {
 		return err
 	}
-	return nil
+	return tree.WriteText(w)
 }
 
-func (p *Project) printTree(w io.Writer, name string, indent int) error {
-	indentStr := strings.Repeat(" ", indent*2)
-	if p == nil {
-		_, err := fmt.Fprintf(w, "%s%s\n", indentStr, name)
-		return err
-	}
-
-	if len(p.Name) > 0 {
-		if _, err := fmt.Fprintf(w, "%s%s (%s)\n", indentStr, name, p.Name); err != nil {
-			return err
-		}
-	} else {
-		if _, err := fmt.Fprintf(w, "%s%s\n", indentStr, name); err != nil {
-			return err
-		}
-	}
-	for _, dep := range p.Dependencies {
-		if err := dep.Project.printTree(w, dep.Name, indent+1); err != nil {
-			return err
-		}
-	}
-	return nil
+func (p *Project) FilePath() string {
+	return p.filePath
 }
 
 func (p *Project) Dir() string {
 	return filepath.Dir(p.filePath)
 }
 
+func (p *Project) LockFilePath() string {
+	return filepath.Join(p.Dir(), lockFileName)
+}
+
 func normalizeProjectPath(path string) string {
 	l := len(path)
 	if l >= 11 && path[l-11:] == "opa.project" {
@@ -1,11 +1,15 @@
 package proj
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/johanfylling/odm/printer"
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -121,6 +125,85 @@ version: 0.0.1
 source: src
 dependencies:
     foo: git+https://example.com/my/repo
+`,
+		},
+		{
+			note: "git dependency with version",
+			project: &Project{
+				Name:       "test_project",
+				Version:    "0.0.1",
+				SourceDirs: []string{"src"},
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "git+https://example.com/my/repo",
+							Namespace: "foo",
+							Version:   "^1.2.0",
+						},
+					},
+				},
+			},
+			expected: `name: test_project
+version: 0.0.1
+source: src
+dependencies:
+    foo:
+        location: git+https://example.com/my/repo
+        version: ^1.2.0
+`,
+		},
+		{
+			note: "git dependency with branch",
+			project: &Project{
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "git+https://example.com/my/repo",
+							Namespace: "foo",
+							Branch:    "main",
+						},
+					},
+				},
+			},
+			expected: `dependencies:
+    foo:
+        branch: main
+        location: git+https://example.com/my/repo
+`,
+		},
+		{
+			note: "registry dependency with version",
+			project: &Project{
+				Name:     "test_project",
+				Registry: "https://registry.example.com",
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "registry:foo",
+							Namespace: "foo",
+							Version:   "1.2",
+						},
+					},
+					"bar": Dependency{
+						Name: "bar",
+						DependencyInfo: DependencyInfo{
+							Location:  "registry:baz",
+							Namespace: "bar",
+							Version:   "^1.0",
+						},
+					},
+				},
+			},
+			expected: `name: test_project
+dependencies:
+    bar:
+        location: registry:baz
+        version: ^1.0
+    foo: "1.2"
+registry: https://registry.example.com
 `,
 		},
 	}
@@ -157,6 +240,80 @@ source: src
 				SourceDirs: []string{"src"},
 			},
 		},
+		{
+			note: "archive dependency with checksum",
+			input: `dependencies:
+    foo:
+        location: https://example.com/foo.tar.gz
+        sha256: 2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+`,
+			expected: &Project{
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "https://example.com/foo.tar.gz",
+							Namespace: "foo",
+							Sha256:    "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+						},
+					},
+				},
+			},
+		},
+		{
+			note: "registry dependencies",
+			input: `registry: ../registry
+dependencies:
+    foo: ^1.0
+    bar: 1.2
+    baz:
+        version: ">=1.4, <2"
+`,
+			expected: &Project{
+				Registry: "../registry",
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "registry:foo",
+							Namespace: "foo",
+							Version:   "^1.0",
+						},
+					},
+					"bar": Dependency{
+						Name: "bar",
+						DependencyInfo: DependencyInfo{
+							Location:  "registry:bar",
+							Namespace: "bar",
+							Version:   "1.2",
+						},
+					},
+					"baz": Dependency{
+						Name: "baz",
+						DependencyInfo: DependencyInfo{
+							Location:  "registry:baz",
+							Namespace: "baz",
+							Version:   ">=1.4, <2",
+						},
+					},
+				},
+			},
+		},
+		{
+			note: "imports",
+			input: `source: src
+imports:
+    authz: utils.authz
+    lists: sl
+`,
+			expected: &Project{
+				SourceDirs: []string{"src"},
+				Imports: map[string]string{
+					"authz": "utils.authz",
+					"lists": "sl",
+				},
+			},
+		},
 		{
 			note: "file dependency with only simplified name & location",
 			input: `name: test_project
@@ -279,6 +436,52 @@ dependencies:
 				},
 			},
 		},
+		{
+			note: "git dependency with version",
+			input: `name: test_project
+version: 0.0.1
+source: src
+dependencies:
+    foo:
+        location: git+https://example.com/my/repo
+        version: ">=1.4, <2"
+`,
+			expected: &Project{
+				Name:       "test_project",
+				Version:    "0.0.1",
+				SourceDirs: []string{"src"},
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "git+https://example.com/my/repo",
+							Namespace: "foo",
+							Version:   ">=1.4, <2",
+						},
+					},
+				},
+			},
+		},
+		{
+			note: "git dependency with branch",
+			input: `dependencies:
+    foo:
+        location: git+https://example.com/my/repo
+        branch: main
+`,
+			expected: &Project{
+				Dependencies: Dependencies{
+					"foo": Dependency{
+						Name: "foo",
+						DependencyInfo: DependencyInfo{
+							Location:  "git+https://example.com/my/repo",
+							Namespace: "foo",
+							Branch:    "main",
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -391,6 +594,176 @@ source: baz`,
 	}
 }
 
+func TestParallelUpdate(t *testing.T) {
+	const count = 8
+
+	var project strings.Builder
+	project.WriteString("dependencies:\n")
+	files := map[string]string{
+		"shared/policy.rego": "package shared\n",
+	}
+	for i := 0; i < count; i++ {
+		// Local dependencies without namespace all share the same transitive dependency directory
+		fmt.Fprintf(&project, "  local%d:\n    location: file:/local%d\n    namespace: false\n", i, i)
+		files[fmt.Sprintf("local%d/policy.rego", i)] = fmt.Sprintf("package local%d\n", i)
+		files[fmt.Sprintf("local%d/opa.project", i)] = "dependencies:\n  shared: file:/shared\n"
+
+		repo := initGitRepo(t, t.TempDir())
+		tagCommit(t, repo, "v1", commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nx := %d\n", i)))
+		bareDir := t.TempDir()
+		pushToBareRepo(t, repo, bareDir)
+		fmt.Fprintf(&project, "  git%d: git+file://%s#v1\n", i, bareDir)
+	}
+
+	tests := []struct {
+		note        string
+		broken      []string
+		expectedErr string
+	}{
+		{
+			note: "all dependencies updated",
+		},
+		{
+			note:        "failed dependency reported, not canceled ones",
+			broken:      []string{"broken"},
+			expectedErr: "failed to update dependency broken: dependency",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := copyFiles(files)
+			files["opa.project"] = project.String()
+			for _, name := range tc.broken {
+				files["opa.project"] += fmt.Sprintf("  %s: file:/%s\n", name, name)
+			}
+
+			err := withTempFiles(files, func(path string) {
+				var expectedLock []byte
+				for _, jobs := range []int{1, 4, 0} {
+					if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.RemoveAll(filepath.Join(path, lockFileName)); err != nil {
+						t.Fatal(err)
+					}
+
+					project, err := ReadProjectFromFile(path, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+					err = project.Update(UpdateOptions{Jobs: jobs})
+					if tc.expectedErr != "" {
+						if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+							t.Fatalf("jobs %d: expected error containing '%s', got %v", jobs, tc.expectedErr, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatalf("jobs %d: %v", jobs, err)
+					}
+
+					for i := 0; i < count; i++ {
+						dep := project.Dependencies[fmt.Sprintf("git%d", i)]
+						expectFileContent(t, filepath.Join(dep.dir(dependenciesDir(path)), "policy.rego"),
+							fmt.Sprintf("package git%d.lib\n\nx := %d\n", i, i))
+					}
+					shared := Dependency{DependencyInfo: DependencyInfo{Location: "file:/shared", Namespace: "shared"}}
+					expectFileContent(t, filepath.Join(shared.dir(dependenciesDir(path)), "policy.rego"), "package shared.shared\n")
+
+					lock, err := os.ReadFile(filepath.Join(path, lockFileName))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if expectedLock == nil {
+						expectedLock = lock
+					} else if string(lock) != string(expectedLock) {
+						t.Fatalf("jobs %d: expected lock file:\n%s\ngot:\n%s", jobs, expectedLock, lock)
+					}
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestParallelUpdateOutputOrder(t *testing.T) {
+	files := map[string]string{
+		"opa.project": "dependencies:\n",
+	}
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	for _, name := range names {
+		files["opa.project"] += fmt.Sprintf("  %s: file:/%s\n", name, name)
+		files[name+"/opa.project"] = fmt.Sprintf("dependencies:\n  %s_child: file:/%s_child\n", name, name)
+		files[name+"/policy.rego"] = fmt.Sprintf("package %s\n", name)
+		files[name+"_child/policy.rego"] = fmt.Sprintf("package %s_child\n", name)
+	}
+
+	var log bytes.Buffer
+	printer.LogWriter = &log
+	printer.LogLevel = printer.DebugLevel
+	defer func() {
+		printer.LogWriter = os.Stderr
+		printer.LogLevel = printer.OutputLevel
+	}()
+
+	// Staging directories are randomly named
+	staging := regexp.MustCompile(`\.staging-\d+`)
+
+	err := withTempFiles(files, func(path string) {
+		var expected string
+		for i := 0; i < 5; i++ {
+			if err := os.RemoveAll(filepath.Join(path, dotOpaDir)); err != nil {
+				t.Fatal(err)
+			}
+			log.Reset()
+
+			project, err := ReadProjectFromFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := project.Update(UpdateOptions{Jobs: len(names)}); err != nil {
+				t.Fatal(err)
+			}
+
+			actual := staging.ReplaceAllString(log.String(), ".staging")
+			if i == 0 {
+				expected = actual
+			} else if actual != expected {
+				t.Fatalf("expected output:\n%s\ngot:\n%s", expected, actual)
+			}
+		}
+
+		// Every dependency is reported together with its transitive dependency, in order of name
+		var order []string
+		for _, line := range strings.Split(expected, "\n") {
+			if dep, ok := strings.CutPrefix(line, "Updating local dependency "); ok {
+				order = append(order, dep)
+			}
+		}
+		var expectedOrder []string
+		for _, name := range names {
+			expectedOrder = append(expectedOrder, name, name+"_child")
+		}
+		if !reflect.DeepEqual(order, expectedOrder) {
+			t.Fatalf("expected dependencies to be reported in order %v, got %v", expectedOrder, order)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFiles(files map[string]string) map[string]string {
+	cpy := make(map[string]string, len(files))
+	for name, content := range files {
+		cpy[name] = content
+	}
+	return cpy
+}
+
 func withTempFiles(files map[string]string, f func(string)) error {
 	root, err := os.MkdirTemp("", "test-")
 	if err != nil {
@@ -0,0 +1,351 @@
+package proj
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io/fs"
+	"oras.land/oras-go/v2/errdef"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packageConfigMediaType is the media type of the config of the OCI artifacts published by odm, holding the name and
+// version of the package
+const packageConfigMediaType = "application/vnd.odm.package.config.v1+json"
+
+type PublishOptions struct {
+	// OutputDir is the directory the package archive is written to
+	OutputDir string
+	// Registry is the directory of a local registry the package is published to, if set
+	Registry string
+	// Oci is the oci:// location of the repository the package is pushed to, if set; tagged with its version
+	Oci string
+}
+
+// Package is a published version of a project.
+type Package struct {
+	Name    string
+	Version string
+	// Archive is the file the package archive was written to
+	Archive string
+	Sha256  string
+}
+
+// Publish packages the project's opa.project, and its source and test directories, into a versioned archive, named
+// by the project's name and version; and publishes it to a local registry and OCI repository, if set.
+// A version already published to either fails, before anything is published.
+func (p *Project) Publish(ctx context.Context, opts PublishOptions) (*Package, error) {
+	version, err := packageVersion(p.Name, p.Version)
+	if err != nil {
+		return nil, fmt.Errorf("can't publish project: %w", err)
+	}
+	for _, name := range p.dependencyNames() {
+		location := strings.TrimPrefix(p.Dependencies[name].Location, bundlePrefix)
+		if strings.HasPrefix(location, "file:") {
+			return nil, fmt.Errorf("can't publish project: dependency %s has local location %s, which can't be resolved by consumers of the package",
+				name, p.Dependencies[name].Location)
+		}
+	}
+
+	var index *registryIndex
+	if opts.Registry != "" {
+		if index, err = readRegistryIndex(opts.Registry, p.Name); err != nil {
+			return nil, err
+		}
+		if index.find(version) != nil {
+			return nil, fmt.Errorf("version %s of %s already exists in registry %s", version, p.Name, opts.Registry)
+		}
+	}
+	var target *ociTarget
+	if opts.Oci != "" {
+		if target, err = newOciTarget(opts.Oci); err != nil {
+			return nil, err
+		}
+		if exists, err := target.exists(ctx, version); err != nil {
+			return nil, err
+		} else if exists {
+			return nil, fmt.Errorf("version %s of %s already exists in %s", version, p.Name, opts.Oci)
+		}
+	}
+
+	// The output of publishing isn't packaged, should it be written into the project's sources
+	var exclude []string
+	for _, dir := range []string{opts.OutputDir, opts.Registry} {
+		if dir == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			exclude = append(exclude, abs)
+		}
+	}
+	data, err := p.packageArchive(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to package project: %w", err)
+	}
+	pkg := &Package{
+		Name:    p.Name,
+		Version: version,
+		Archive: filepath.Join(opts.OutputDir, packageArchiveName(p.Name, version)),
+		Sha256:  fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+	if err := utils.MakeDir(opts.OutputDir); err != nil {
+		return nil, fmt.Errorf("failed to create output directory %s: %w", opts.OutputDir, err)
+	}
+	if err := os.WriteFile(pkg.Archive, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write package archive %s: %w", pkg.Archive, err)
+	}
+	printer.Debug("Packaged %s %s into %s", p.Name, version, pkg.Archive)
+
+	if index != nil {
+		if err := publishToRegistry(opts.Registry, index, pkg, data); err != nil {
+			return nil, err
+		}
+		printer.Info("Published %s %s to registry %s", p.Name, version, opts.Registry)
+	}
+	if target != nil {
+		if err := target.push(ctx, pkg, data); err != nil {
+			return nil, fmt.Errorf("failed to push %s %s to %s: %w", p.Name, version, opts.Oci, err)
+		}
+		printer.Info("Pushed %s %s to %s:%s", p.Name, version, opts.Oci, version)
+	}
+	return pkg, nil
+}
+
+// packageArchive returns a gzipped tarball of the project's opa.project, and its source and test directories; or the
+// project directory, if no source directories are declared. Hidden files and directories, such as .opa and .git, the
+// project's opa.lock, as consumers resolve dependencies of their own, its built bundle, and the directories in exclude,
+// are left out. Entries are written in order, without timestamps, so that the same files are always packaged into the
+// same archive.
+func (p *Project) packageArchive(exclude []string) ([]byte, error) {
+	projDir, err := filepath.Abs(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	if len(p.SourceDirs) == 0 {
+		dirs = []string{projDir}
+	}
+	for _, dir := range append(p.SourceDirs, p.TestDirs...) {
+		dir, err := utils.NormalizeFilePath(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, filepath.Join(projDir, dir))
+	}
+
+	files := map[string]string{
+		"opa.project": filepath.Join(projDir, "opa.project"),
+	}
+	lockFile := filepath.Join(projDir, lockFileName)
+	if p.Build.Output != "" {
+		exclude = append(append([]string(nil), exclude...), filepath.Join(projDir, p.Build.Output))
+	}
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if dir != projDir && !strings.HasPrefix(dir, projDir+string(filepath.Separator)) {
+			return nil, fmt.Errorf("directory %s is outside the project", dir)
+		}
+		if !utils.FileExists(dir) {
+			continue
+		}
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != dir && (strings.HasPrefix(entry.Name(), ".") || utils.Contains(exclude, path)) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() || path == lockFile {
+				return nil
+			}
+			rel, err := filepath.Rel(projDir, path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = path
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		data, err := os.ReadFile(files[name])
+		if err != nil {
+			return nil, err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(0, 0),
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// publishToRegistry writes the package archive into the package's directory of the local registry, and adds it to
+// the package's index.
+func publishToRegistry(registryDir string, index *registryIndex, pkg *Package, data []byte) error {
+	dir := filepath.Join(registryDir, pkg.Name)
+	if err := utils.MakeDir(dir); err != nil {
+		return fmt.Errorf("failed to create registry directory %s: %w", dir, err)
+	}
+	archive := packageArchiveName(pkg.Name, pkg.Version)
+	if err := os.WriteFile(filepath.Join(dir, archive), data, 0644); err != nil {
+		return fmt.Errorf("failed to write package archive to registry %s: %w", registryDir, err)
+	}
+	index.Versions = append(index.Versions, registryVersion{
+		Version: pkg.Version,
+		Archive: archive,
+		Sha256:  pkg.Sha256,
+	})
+	if err := index.write(registryDir); err != nil {
+		return fmt.Errorf("failed to write index of registry %s: %w", registryDir, err)
+	}
+	return nil
+}
+
+// ociTarget is an OCI repository packages are pushed to.
+type ociTarget struct {
+	location string
+	dep      Dependency
+}
+
+func newOciTarget(location string) (*ociTarget, error) {
+	if !isOciLocation(location) {
+		return nil, fmt.Errorf("invalid OCI location %s; expected oci://<registry>/<repository>", location)
+	}
+	t := &ociTarget{
+		location: location,
+		dep:      Dependency{DependencyInfo: DependencyInfo{Location: location}},
+	}
+	ref, err := t.dep.ociReference()
+	if err != nil {
+		return nil, err
+	}
+	if ref.Reference != "" {
+		return nil, fmt.Errorf("invalid OCI location %s; packages are tagged with their version, and can't be pushed to a tag or digest", location)
+	}
+	return t, nil
+}
+
+func (t *ociTarget) exists(ctx context.Context, version string) (bool, error) {
+	ref, err := t.dep.ociReference()
+	if err != nil {
+		return false, err
+	}
+	repo, err := ociRepository(ref)
+	if err != nil {
+		return false, fmt.Errorf("invalid OCI location %s: %w", t.location, err)
+	}
+	if _, err := repo.Resolve(ctx, version); err != nil {
+		if errors.Is(err, errdef.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to look up version %s in %s: %w", version, t.location, err)
+	}
+	return true, nil
+}
+
+// push pushes the package archive as the single layer of an artifact, tagged with the version of the package. The
+// layer is a gzipped tarball, and so is extracted by dependencies on the artifact.
+func (t *ociTarget) push(ctx context.Context, pkg *Package, data []byte) error {
+	ref, err := t.dep.ociReference()
+	if err != nil {
+		return err
+	}
+	repo, err := ociRepository(ref)
+	if err != nil {
+		return err
+	}
+
+	config, err := json.Marshal(map[string]string{
+		"name":    pkg.Name,
+		"version": pkg.Version,
+	})
+	if err != nil {
+		return err
+	}
+	configDesc := ocispec.Descriptor{
+		MediaType: packageConfigMediaType,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}
+	layerDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle: packageArchiveName(pkg.Name, pkg.Version),
+		},
+	}
+	for _, blob := range []struct {
+		desc ocispec.Descriptor
+		data []byte
+	}{{configDesc, config}, {layerDesc, data}} {
+		if err := repo.Push(ctx, blob.desc, bytes.NewReader(blob.data)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+			return err
+		}
+	}
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+		Annotations: map[string]string{
+			ocispec.AnnotationTitle:   pkg.Name,
+			ocispec.AnnotationVersion: pkg.Version,
+		},
+	}
+	manifest.SchemaVersion = 2
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifestData),
+		Size:      int64(len(manifestData)),
+	}
+	return repo.PushReference(ctx, manifestDesc, bytes.NewReader(manifestData), pkg.Version)
+}
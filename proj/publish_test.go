@@ -0,0 +1,280 @@
+package proj
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	tests := []struct {
+		note          string
+		files         map[string]string
+		expectedFiles []string
+		expectedErr   string
+	}{
+		{
+			note: "source and test dirs",
+			files: map[string]string{
+				"opa.project":       "name: lib\nversion: 1.0.0\nsource: src\ntests: tst\n",
+				"src/policy.rego":   "package lib\n",
+				"src/data.json":     `{}`,
+				"src/.hidden.rego":  "package hidden\n",
+				"tst/test.rego":     "package lib_test\n",
+				"other/other.rego":  "package other\n",
+				".opa/cached.rego":  "package cached\n",
+				".git/HEAD":         "ref: refs/heads/main\n",
+				"build/bundle.json": `{}`,
+			},
+			expectedFiles: []string{"opa.project", "src/data.json", "src/policy.rego", "tst/test.rego"},
+		},
+		{
+			note: "no source dirs",
+			files: map[string]string{
+				"opa.project":         "name: lib\nversion: v1.0\n",
+				"opa.lock":            "dependencies: []\n",
+				"README.md":           "# lib\n",
+				"policy.rego":         "package lib\n",
+				"sub/data.yaml":       "x: 1\n",
+				"sub/opa.lock":        "dependencies: []\n",
+				".opa/cached.rego":    "package cached\n",
+				"build/bundle.tar.gz": "bundle",
+			},
+			expectedFiles: []string{"README.md", "opa.project", "policy.rego", "sub/data.yaml", "sub/opa.lock"},
+		},
+		{
+			note: "no source dirs, built bundle",
+			files: map[string]string{
+				"opa.project":        "name: lib\nversion: 1.0.0\nbuild:\n  output: dist/bundle.tar.gz\n",
+				"policy.rego":        "package lib\n",
+				"dist/bundle.tar.gz": "bundle",
+				"dist/notes.txt":     "notes\n",
+			},
+			expectedFiles: []string{"dist/notes.txt", "opa.project", "policy.rego"},
+		},
+		{
+			note: "no name",
+			files: map[string]string{
+				"opa.project": "version: 1.0.0\n",
+			},
+			expectedErr: "can't publish project: project has no name",
+		},
+		{
+			note: "invalid name",
+			files: map[string]string{
+				"opa.project": "name: My Lib\nversion: 1.0.0\n",
+			},
+			expectedErr: "can't publish project: invalid project name My Lib",
+		},
+		{
+			note: "no version",
+			files: map[string]string{
+				"opa.project": "name: lib\n",
+			},
+			expectedErr: "can't publish project: project has no version",
+		},
+		{
+			note: "invalid version",
+			files: map[string]string{
+				"opa.project": "name: lib\nversion: latest\n",
+			},
+			expectedErr: "can't publish project: invalid project version latest",
+		},
+		{
+			note: "local dependency",
+			files: map[string]string{
+				"opa.project": "name: lib\nversion: 1.0.0\ndependencies:\n  a: file:/../a\n",
+			},
+			expectedErr: "can't publish project: dependency a has local location file:/../a",
+		},
+		{
+			note: "source dir outside project",
+			files: map[string]string{
+				"opa.project": "name: lib\nversion: 1.0.0\nsource: ../src\n",
+			},
+			expectedErr: "is outside the project",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(tc.files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pkg, err := project.Publish(context.Background(), PublishOptions{OutputDir: filepath.Join(path, "build")})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if expected := filepath.Join(path, "build", "lib-1.0.0.tar.gz"); pkg.Archive != expected {
+					t.Fatalf("expected archive %s, got %s", expected, pkg.Archive)
+				}
+				data, err := os.ReadFile(pkg.Archive)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if sum := fmt.Sprintf("%x", sha256.Sum256(data)); pkg.Sha256 != sum {
+					t.Fatalf("expected sha256 %s, got %s", sum, pkg.Sha256)
+				}
+				if names := tarGzFileNames(t, data); !reflect.DeepEqual(names, tc.expectedFiles) {
+					t.Fatalf("expected files %v, got %v", tc.expectedFiles, names)
+				}
+
+				// Packaging the same files again yields the same archive
+				again, err := project.packageArchive([]string{filepath.Join(path, "build")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, again) {
+					t.Fatal("expected packaging to be reproducible")
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPublishToRegistry(t *testing.T) {
+	files := map[string]string{
+		"lib/opa.project":     "name: lib\nversion: 1.0.0\nsource: src\n",
+		"lib/src/policy.rego": "package lib\n",
+	}
+	err := withTempFiles(files, func(root string) {
+		registryDir := filepath.Join(root, "registry")
+		opts := PublishOptions{OutputDir: filepath.Join(root, "build"), Registry: registryDir}
+
+		publish := func(version string) (*Package, error) {
+			project, err := ReadProjectFromFile(filepath.Join(root, "lib"), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			project.Version = version
+			return project.Publish(context.Background(), opts)
+		}
+
+		var sums []string
+		for _, version := range []string{"1.1.0", "1.0.0"} {
+			pkg, err := publish(version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sums = append(sums, pkg.Sha256)
+		}
+		if _, err := publish("1.0.0"); err == nil || err.Error() != fmt.Sprintf("version 1.0.0 of lib already exists in registry %s", registryDir) {
+			t.Fatalf("expected existing version to fail, got %v", err)
+		}
+
+		expectFileContent(t, filepath.Join(registryDir, "lib", "index.json"), fmt.Sprintf(`{
+  "name": "lib",
+  "versions": [
+    {
+      "version": "1.0.0",
+      "archive": "lib-1.0.0.tar.gz",
+      "sha256": "%s"
+    },
+    {
+      "version": "1.1.0",
+      "archive": "lib-1.1.0.tar.gz",
+      "sha256": "%s"
+    }
+  ]
+}
+`, sums[1], sums[0]))
+		for _, archive := range []string{"lib-1.0.0.tar.gz", "lib-1.1.0.tar.gz"} {
+			if _, err := os.Stat(filepath.Join(registryDir, "lib", archive)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishToOci(t *testing.T) {
+	registry := newTestRegistry()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	files := map[string]string{
+		"lib/opa.project":     "name: lib\nversion: 1.0.0\nsource: src\n",
+		"lib/src/policy.rego": "package lib\n\nallow := true\n",
+		"app/opa.project":     "dependencies:\n  lib: oci://" + host + "/policies/lib:1.0.0\n",
+	}
+	err := withTempFiles(files, func(root string) {
+		opts := PublishOptions{OutputDir: filepath.Join(root, "build"), Oci: "oci://" + host + "/policies/lib"}
+
+		project, err := ReadProjectFromFile(filepath.Join(root, "lib"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := project.Publish(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := project.Publish(context.Background(), opts); err == nil ||
+			err.Error() != "version 1.0.0 of lib already exists in oci://"+host+"/policies/lib" {
+			t.Fatalf("expected existing version to fail, got %v", err)
+		}
+
+		// The pushed package is consumed as an OCI dependency
+		app, err := ReadProjectFromFile(filepath.Join(root, "app"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		libDir := app.Dependencies["lib"].dir(dependenciesDir(filepath.Join(root, "app")))
+		expectFileContent(t, filepath.Join(libDir, "src", "policy.rego"), "package lib.lib\n\nallow := true\n")
+
+		opts.Oci = "oci://" + host + "/policies/lib:1.0.0"
+		if _, err := project.Publish(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "can't be pushed to a tag or digest") {
+			t.Fatalf("expected tagged location to fail, got %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tarGzFileNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+}
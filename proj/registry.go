@@ -0,0 +1,414 @@
+package proj
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// registryIndexFile is the index of the published versions of a package, in the package's directory of a registry:
+//
+//	<registry>/<name>/index.json
+//	<registry>/<name>/<name>-<version>.tar.gz
+const registryIndexFile = "index.json"
+
+// maxIndexSize is the maximum size of a registry index downloaded over HTTP(S)
+const maxIndexSize = 16 << 20
+
+// packageNamePattern restricts package names to those valid as directory names, and as OCI repository path
+// components, everywhere
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// registryIndex lists the published versions of a package.
+type registryIndex struct {
+	Name     string            `json:"name"`
+	Versions []registryVersion `json:"versions"`
+}
+
+type registryVersion struct {
+	Version string `json:"version"`
+	// Archive is the location of the version's archive, relative to the index
+	Archive string `json:"archive"`
+	Sha256  string `json:"sha256"`
+}
+
+// packageVersion validates the name and version of a package, returning the canonical form of the version.
+func packageVersion(name, version string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("project has no name")
+	}
+	if !packageNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid project name %s; expected lowercase letters and digits, separated by '.', '_', or '-'", name)
+	}
+	if version == "" {
+		return "", fmt.Errorf("project has no version")
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("invalid project version %s; expected a semantic version: %w", version, err)
+	}
+	return v.String(), nil
+}
+
+func packageArchiveName(name, version string) string {
+	return fmt.Sprintf("%s-%s.tar.gz", name, version)
+}
+
+// readRegistryIndex reads the index of the package name in the registry directory; an empty index if the package
+// hasn't been published to it.
+func readRegistryIndex(registryDir, name string) (*registryIndex, error) {
+	file := filepath.Join(registryDir, name, registryIndexFile)
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &registryIndex{Name: name}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry index %s: %w", file, err)
+	}
+	return parseRegistryIndex(file, data)
+}
+
+func parseRegistryIndex(location string, data []byte) (*registryIndex, error) {
+	var index registryIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid registry index %s: %w", location, err)
+	}
+	return &index, nil
+}
+
+// find returns version, if published.
+func (idx *registryIndex) find(version string) *registryVersion {
+	for i := range idx.Versions {
+		if idx.Versions[i].Version == version {
+			return &idx.Versions[i]
+		}
+	}
+	return nil
+}
+
+// write writes the index, with its versions in ascending order, to the package's directory of the registry.
+func (idx *registryIndex) write(registryDir string) error {
+	sort.SliceStable(idx.Versions, func(i, j int) bool {
+		vi, erri := semver.NewVersion(idx.Versions[i].Version)
+		vj, errj := semver.NewVersion(idx.Versions[j].Version)
+		if erri != nil || errj != nil {
+			return idx.Versions[i].Version < idx.Versions[j].Version
+		}
+		return vi.LessThan(vj)
+	})
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(registryDir, idx.Name)
+	if err := utils.MakeDir(dir); err != nil {
+		return err
+	}
+	// The index is replaced atomically, so that it never refers to a version whose archive isn't yet written, nor is
+	// read half-written
+	tmp, err := os.CreateTemp(dir, ".index-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, registryIndexFile))
+}
+
+const (
+	registryPrefix = "registry:"
+	// RefTypeVersion is the type of the versions of packages, selected from a registry
+	RefTypeVersion = "version"
+)
+
+// isLocal returns true if the dependency is copied from a local directory.
+func (d Dependency) isLocal() bool {
+	location := strings.TrimPrefix(d.Location, bundlePrefix)
+	return !strings.HasPrefix(location, "git+") && !isArchiveLocation(location) && !isOciLocation(location) &&
+		!isRegistryLocation(location)
+}
+
+func isRegistryLocation(location string) bool {
+	return strings.HasPrefix(location, registryPrefix)
+}
+
+// isRegistryShortForm reports whether the short form of a dependency is a version constraint on a registry package,
+// rather than a location.
+func isRegistryShortForm(value string) bool {
+	if value == "" || strings.Contains(value, ":") {
+		return false
+	}
+	_, err := semver.NewConstraint(value)
+	return err == nil
+}
+
+// ShortFormDependency returns the dependency name declared in its short form by value; either a location, or a version
+// constraint on the registry package of the same name.
+func ShortFormDependency(name, value string) DependencyInfo {
+	if isRegistryShortForm(value) {
+		return DependencyInfo{
+			Location:  registryPrefix + name,
+			Namespace: name,
+			Version:   value,
+		}
+	}
+	return DependencyInfo{
+		Location:  value,
+		Namespace: name,
+	}
+}
+
+func registryLocation(name, version string) string {
+	return fmt.Sprintf("%s%s@%s", registryPrefix, name, version)
+}
+
+// registryPackage returns the name of the package a registry dependency is declared on, and the version it's pinned
+// at, if any; registry:<name>[@<version>].
+func (d Dependency) registryPackage() (name string, version string, err error) {
+	name, version, _ = strings.Cut(strings.TrimPrefix(d.Location, registryPrefix), "@")
+	if !packageNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("invalid package name %s of dependency %s", name, d.Name)
+	}
+	if version != "" {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid package version %s of dependency %s: %w", version, d.Name, err)
+		}
+		version = v.String()
+	}
+	return name, version, nil
+}
+
+// registry returns the package registry a registry dependency is resolved against; the one declared by the nearest
+// of the project declaring the dependency, and the projects depending on it. A registry path is relative to the
+// directory of the project declaring it; which, for a local dependency, is where it's copied from.
+func (d Dependency) registry(u *updater) (*packageRegistry, error) {
+	location, dir := u.registry, u.rootDir
+	for p := d.ParentDependency; p != nil; p = p.ParentDependency {
+		if p.Project == nil || p.Project.Registry == "" {
+			continue
+		}
+		location, dir = p.Project.Registry, p.Project.Dir()
+		if p.isLocal() {
+			src, err := p.localSource(u.rootDir)
+			if err != nil {
+				return nil, err
+			}
+			if utils.IsDir(src) {
+				dir = src
+			} else {
+				dir = filepath.Dir(src)
+			}
+		}
+		break
+	}
+	if location == "" {
+		return nil, fmt.Errorf("dependency %s is declared on a registry package, but no registry is declared", d.Name)
+	}
+	return openRegistry(dir, location)
+}
+
+// packageRegistry is a registry of package archives; a directory, or served over HTTP(S).
+type packageRegistry struct {
+	location string
+	// dir is the directory of a local registry; and empty for one served over HTTP(S)
+	dir string
+}
+
+// openRegistry returns the registry at location; the URL of a registry served over HTTP(S), or a path, optionally
+// prefixed by file:, relative to rootDir.
+func openRegistry(rootDir, location string) (*packageRegistry, error) {
+	if isHttpLocation(location) {
+		return &packageRegistry{location: strings.TrimSuffix(location, "/")}, nil
+	}
+	dir, err := utils.NormalizeFilePath(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry location %s: %w", location, err)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(rootDir, dir)
+	}
+	return &packageRegistry{location: location, dir: dir}, nil
+}
+
+func (r *packageRegistry) remote() bool {
+	return r.dir == ""
+}
+
+func (r *packageRegistry) indexLocation(name string) string {
+	if r.remote() {
+		return fmt.Sprintf("%s/%s/%s", r.location, name, registryIndexFile)
+	}
+	return filepath.Join(r.dir, name, registryIndexFile)
+}
+
+// index reads the index of the package name. The index of a remote registry is downloaded; and isn't available
+// offline.
+func (r *packageRegistry) index(ctx context.Context, name string, offline bool) (*registryIndex, error) {
+	location := r.indexLocation(name)
+	if !r.remote() {
+		if !utils.FileExists(location) {
+			return nil, fmt.Errorf("package %s not found in registry %s", name, r.location)
+		}
+		return readRegistryIndex(r.dir, name)
+	}
+	if offline {
+		return nil, fmt.Errorf("index of package %s in registry %s %w", name, r.location, errNotCached)
+	}
+
+	printer.From(ctx).Debug("Downloading %s", location)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry location %s: %w", r.location, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("package %s not found in registry %s", name, r.location)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", location, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIndexSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	return parseRegistryIndex(location, data)
+}
+
+// archiveLocation returns the location of the archive of version, listed in the index of the package name; relative to
+// the index.
+func (r *packageRegistry) archiveLocation(name string, version *registryVersion) (string, error) {
+	if r.remote() {
+		index, err := url.Parse(r.indexLocation(name))
+		if err != nil {
+			return "", err
+		}
+		archive, err := index.Parse(version.Archive)
+		if err != nil {
+			return "", fmt.Errorf("invalid archive location %s of version %s of package %s: %w", version.Archive, version.Version, name, err)
+		}
+		return archive.String(), nil
+	}
+	archive, err := archiveEntryPath(filepath.Join(r.dir, name), version.Archive)
+	if err != nil {
+		return "", fmt.Errorf("invalid archive location %s of version %s of package %s: %w", version.Archive, version.Version, name, err)
+	}
+	return archive, nil
+}
+
+// selectVersion returns the highest version in the index matching constraint; or the highest version, other than a
+// pre-release, if no constraint is given.
+func (idx *registryIndex) selectVersion(constraint string) (*registryVersion, error) {
+	versions := make([]string, 0, len(idx.Versions))
+	for _, item := range idx.Versions {
+		versions = append(versions, item.Version)
+	}
+	if constraint == "" {
+		version := latestVersion(versions)
+		if version == "" {
+			return nil, fmt.Errorf("no released version")
+		}
+		return idx.find(version), nil
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint '%s': %w", constraint, err)
+	}
+	var selected *registryVersion
+	var selectedVersion *semver.Version
+	for i, item := range idx.Versions {
+		v, err := semver.NewVersion(item.Version)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if selected == nil || v.GreaterThan(selectedVersion) {
+			selected = &idx.Versions[i]
+			selectedVersion = v
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("no version matches version constraint '%s'", constraint)
+	}
+	return selected, nil
+}
+
+// fetchPackage fetches the archive of a version of the package a registry dependency is declared on; version, if set,
+// or otherwise the highest version in the registry matching the dependency's version constraint. It returns the
+// directory of the archive's content, the version, and the archive's sha256 checksum.
+// The archive is verified against the checksum listed by the registry, and expected, if set; and cached like any other
+// archive. A version whose expected archive is already cached isn't looked up in the registry again.
+func (d Dependency) fetchPackage(ctx context.Context, registry *packageRegistry, version, expected string, offline bool) (dir string, resolved string, sum string, err error) {
+	name, _, err := d.registryPackage()
+	if err != nil {
+		return "", "", "", err
+	}
+	if version != "" && expected != "" {
+		if dir, err := cachedArchiveDir(expected, false); err != nil {
+			return "", "", "", err
+		} else if utils.FileExists(dir) {
+			printer.From(ctx).Debug("Using cached archive %s for %s %s", expected, name, version)
+			return dir, version, expected, nil
+		}
+	}
+
+	index, err := registry.index(ctx, name, offline)
+	if err != nil {
+		return "", "", "", err
+	}
+	var item *registryVersion
+	if version != "" {
+		if item = index.find(version); item == nil {
+			return "", "", "", fmt.Errorf("version %s of package %s not found in registry %s", version, name, registry.location)
+		}
+	} else if item, err = index.selectVersion(d.Version); err != nil {
+		return "", "", "", fmt.Errorf("failed to select version of package %s in registry %s: %w", name, registry.location, err)
+	}
+	if expected != "" && item.Sha256 != expected {
+		return "", "", "", fmt.Errorf("checksum of version %s of package %s in registry %s does not match lock file; expected sha256 %s, got %s",
+			item.Version, name, registry.location, expected, item.Sha256)
+	}
+
+	location, err := registry.archiveLocation(name, item)
+	if err != nil {
+		return "", "", "", err
+	}
+	archive := Dependency{
+		DependencyInfo: DependencyInfo{Location: location, Sha256: item.Sha256},
+		Name:           d.Name,
+	}
+	if dir, sum, err = archive.fetchArchive(ctx, registry.dir, expected, offline); err != nil {
+		return "", "", "", err
+	}
+	return dir, item.Version, sum, nil
+}
@@ -0,0 +1,322 @@
+package proj
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// publishPackages publishes the projects, given by their files, to the registry directory; in order of their keys.
+func publishPackages(t *testing.T, registryDir string, projects map[string]map[string]string) {
+	t.Helper()
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := withTempFiles(projects[name], func(path string) {
+			project, err := ReadProjectFromFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := PublishOptions{OutputDir: filepath.Join(path, "build"), Registry: registryDir}
+			if _, err := project.Publish(context.Background(), opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func libPackage(version string) map[string]string {
+	return map[string]string{
+		"opa.project":     "name: lib\nversion: " + version + "\nsource: src\n",
+		"src/policy.rego": "package lib\n\nversion := \"" + version + "\"\n",
+	}
+}
+
+func TestUpdateRegistryDependency(t *testing.T) {
+	registryDir, err := os.MkdirTemp("", "registry-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(registryDir)
+	}()
+	publishPackages(t, registryDir, map[string]map[string]string{
+		"lib-1.0.0":      libPackage("1.0.0"),
+		"lib-1.1.0":      libPackage("1.1.0"),
+		"lib-2.0.0":      libPackage("2.0.0"),
+		"lib-3.0.0-rc.1": libPackage("3.0.0-rc.1"),
+		"app-1.0.0": {
+			"opa.project":  "name: app\nversion: 1.0.0\nsource: src\ndependencies:\n  lib: ^1.0\n",
+			"src/app.rego": "package app\n\nlib_version := data.lib.version\n",
+		},
+	})
+
+	server := httptest.NewServer(http.FileServer(http.Dir(registryDir)))
+	defer server.Close()
+
+	tests := []struct {
+		note          string
+		project       string
+		expectedFiles map[string]string
+		expectedErr   string
+	}{
+		{
+			note:    "short form",
+			project: "registry: " + registryDir + "\ndependencies:\n  lib: ^1.0\n",
+			expectedFiles: map[string]string{
+				"lib/src/policy.rego": "package lib.lib\n\nversion := \"1.1.0\"\n",
+			},
+		},
+		{
+			note:    "exact version",
+			project: "registry: " + registryDir + "\ndependencies:\n  lib: 1.0.0\n",
+			expectedFiles: map[string]string{
+				"lib/src/policy.rego": "package lib.lib\n\nversion := \"1.0.0\"\n",
+			},
+		},
+		{
+			note:    "latest released version",
+			project: "registry: " + registryDir + "\ndependencies:\n  other:\n    location: registry:lib\n    namespace: x\n",
+			expectedFiles: map[string]string{
+				"other/src/policy.rego": "package x.lib\n\nversion := \"2.0.0\"\n",
+			},
+		},
+		{
+			note:    "pinned location",
+			project: "registry: " + registryDir + "\ndependencies:\n  lib:\n    location: registry:lib@1.0.0\n",
+			expectedFiles: map[string]string{
+				"lib/src/policy.rego": "package lib.lib\n\nversion := \"1.0.0\"\n",
+			},
+		},
+		{
+			note:    "without location",
+			project: "registry: " + registryDir + "\ndependencies:\n  lib:\n    version: \">=1.0, <2\"\n",
+			expectedFiles: map[string]string{
+				"lib/src/policy.rego": "package lib.lib\n\nversion := \"1.1.0\"\n",
+			},
+		},
+		{
+			note:    "served over HTTP",
+			project: "registry: " + server.URL + "\ndependencies:\n  lib: ^2\n",
+			expectedFiles: map[string]string{
+				"lib/src/policy.rego": "package lib.lib\n\nversion := \"2.0.0\"\n",
+			},
+		},
+		{
+			note:    "transitive",
+			project: "registry: " + server.URL + "/\ndependencies:\n  app: ^1\n",
+			expectedFiles: map[string]string{
+				"app/src/app.rego":    "package app.app\n\nlib_version := data.app.lib.version\n",
+				"lib/src/policy.rego": "package app.lib.lib\n\nversion := \"1.1.0\"\n",
+			},
+		},
+		{
+			note:        "no matching version",
+			project:     "registry: " + registryDir + "\ndependencies:\n  lib: ^4\n",
+			expectedErr: "failed to select version of package lib in registry " + registryDir + ": no version matches version constraint '^4'",
+		},
+		{
+			note:        "unknown package",
+			project:     "registry: " + server.URL + "\ndependencies:\n  missing: ^1\n",
+			expectedErr: "package missing not found in registry " + server.URL,
+		},
+		{
+			note:        "unknown pinned version",
+			project:     "registry: " + registryDir + "\ndependencies:\n  lib:\n    location: registry:lib@1.2.0\n",
+			expectedErr: "version 1.2.0 of package lib not found in registry " + registryDir,
+		},
+		{
+			note:        "no registry",
+			project:     "dependencies:\n  lib: ^1\n",
+			expectedErr: "dependency lib is declared on a registry package, but no registry is declared",
+		},
+		{
+			note:        "git ref",
+			project:     "registry: " + registryDir + "\ndependencies:\n  lib:\n    location: registry:lib\n    tag: v1.0.0\n",
+			expectedErr: "only version constraints are supported for registry dependencies: registry:lib",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": tc.project,
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for file, expected := range tc.expectedFiles {
+					name, rest, _ := strings.Cut(file, "/")
+					dep, ok := project.Dependencies[name]
+					if !ok {
+						// Transitive dependencies are declared by app
+						app := project.Dependencies["app"]
+						dep = Dependency{
+							DependencyInfo:   DependencyInfo{Location: registryPrefix + name, Namespace: name},
+							ParentDependency: &app,
+						}
+					}
+					expectFileContent(t, filepath.Join(dep.dir(dependenciesDir(path)), rest), expected)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateRegistryDependencyRelativeRegistry(t *testing.T) {
+	tests := []struct {
+		note        string
+		project     string
+		dependency  string
+		registryDir string
+	}{
+		{
+			note:        "declared by dependency",
+			project:     "dependencies:\n  a: file:/a\n",
+			dependency:  "registry: ../registries/a\ndependencies:\n  lib: ^1\n",
+			registryDir: "registries/a",
+		},
+		{
+			note:        "declared by root project",
+			project:     "registry: registries/root\ndependencies:\n  a: file:/a\n",
+			dependency:  "dependencies:\n  lib: ^1\n",
+			registryDir: "registries/root",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project":   tc.project,
+				"a/opa.project": tc.dependency,
+			}
+			err := withTempFiles(files, func(path string) {
+				publishPackages(t, filepath.Join(path, tc.registryDir), map[string]map[string]string{
+					"lib-1.0.0": libPackage("1.0.0"),
+				})
+
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+
+				a := project.Dependencies["a"]
+				lib := Dependency{
+					DependencyInfo:   DependencyInfo{Location: registryPrefix + "lib", Namespace: "lib"},
+					ParentDependency: &a,
+				}
+				expectFileContent(t, filepath.Join(lib.dir(dependenciesDir(path)), "src", "policy.rego"),
+					"package a.lib.lib\n\nversion := \"1.0.0\"\n")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUpdateRegistryDependencyLocked(t *testing.T) {
+	registryDir, err := os.MkdirTemp("", "registry-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(registryDir)
+	}()
+	publishPackages(t, registryDir, map[string]map[string]string{
+		"lib-1.0.0": libPackage("1.0.0"),
+	})
+
+	var mu sync.Mutex
+	var requests int
+	fileServer := http.FileServer(http.Dir(registryDir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	files := map[string]string{
+		"opa.project": "registry: " + server.URL + "\ndependencies:\n  lib: ^1\n",
+	}
+	err = withTempFiles(files, func(path string) {
+		update := func(opts UpdateOptions, expectedVersion string) {
+			t.Helper()
+			project, err := ReadProjectFromFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := project.Update(opts); err != nil {
+				t.Fatal(err)
+			}
+			expectFileContent(t, filepath.Join(project.Dependencies["lib"].dir(dependenciesDir(path)), "src", "policy.rego"),
+				"package lib.lib\n\nversion := \""+expectedVersion+"\"\n")
+
+			lock, err := ReadLockFromFile(project.LockFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			locked := lock.find(project.Dependencies["lib"].id())
+			if locked == nil || locked.Ref != expectedVersion || locked.RefType != RefTypeVersion {
+				t.Fatalf("expected version %s to be locked, got %v", expectedVersion, locked)
+			}
+		}
+
+		update(UpdateOptions{}, "1.0.0")
+
+		// The locked version is kept, and materialized from the cache without reading the registry, until refreshed
+		publishPackages(t, registryDir, map[string]map[string]string{
+			"lib-1.1.0": libPackage("1.1.0"),
+		})
+		if err := os.RemoveAll(dependenciesDir(path)); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		before := requests
+		mu.Unlock()
+		update(UpdateOptions{Offline: true}, "1.0.0")
+		mu.Lock()
+		if requests != before {
+			t.Fatalf("expected locked version to be materialized from cache, got %d requests", requests-before)
+		}
+		mu.Unlock()
+
+		update(UpdateOptions{Refresh: true}, "1.1.0")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,364 @@
+package proj
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/johanfylling/odm/utils"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// ResolutionIsolated resolves every dependency in the dependency tree independently of any other. This is the default.
+	ResolutionIsolated = "isolated"
+	// ResolutionDeduplicate selects a single version for all dependencies on the same library in the dependency tree.
+	ResolutionDeduplicate = "deduplicate"
+
+	maxResolutionRounds = 10
+)
+
+// libraryNode is a dependency in the dependency tree, as it was fetched during an update.
+type libraryNode struct {
+	dep Dependency
+	// key identifies the library by its location, regardless of version
+	key string
+	// name and version are declared by the project file of the fetched dependency, if any
+	name    string
+	version string
+	// fetched is the location the dependency was fetched from, pinned to the resolved ref, if any
+	fetched string
+	digest  string
+}
+
+func newLibraryNode(d Dependency, fetched Dependency, rootDir string, ref gitRef, digest string) libraryNode {
+	n := libraryNode{
+		dep:     d,
+		key:     d.libraryKey(rootDir),
+		fetched: fetched.Location,
+		digest:  digest,
+	}
+	if d.Project != nil {
+		n.name = d.Project.Name
+		n.version = d.Project.Version
+	}
+	if url, subdir, err := fetched.gitRepository(); err == nil && strings.HasPrefix(fetched.Location, "git+") {
+		n.fetched = gitLocation(url, subdir, ref.name)
+	}
+	if name, _, err := fetched.registryPackage(); err == nil && isRegistryLocation(fetched.Location) && ref.name != "" {
+		n.fetched = registryLocation(name, ref.name)
+	}
+	return n
+}
+
+func (n libraryNode) identities() []string {
+	ids := []string{n.key}
+	if n.name != "" {
+		ids = append(ids, "name:"+n.name)
+	}
+	return ids
+}
+
+func (n libraryNode) requirement() string {
+	if requested := n.dep.requested(); requested != "" {
+		return fmt.Sprintf("%s (%s)", n.dep.Location, requested)
+	}
+	return n.dep.Location
+}
+
+// libraryKey identifies the library the dependency is located at, regardless of its version.
+func (d Dependency) libraryKey(rootDir string) string {
+	if strings.HasPrefix(d.Location, "git+") {
+		if url, subdir, err := d.gitRepository(); err == nil {
+			key := "git:" + strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+			if subdir != "" {
+				key += "//" + subdir
+			}
+			return key
+		}
+	} else if strings.HasPrefix(d.Location, "file:") {
+		if path, err := utils.NormalizeFilePath(d.Location); err == nil {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(rootDir, path)
+			}
+			return "file:" + filepath.Clean(path)
+		}
+	}
+	return d.Location
+}
+
+// path returns the chain of dependency names leading from the root project to the dependency.
+func (d Dependency) path() string {
+	if d.ParentDependency != nil {
+		return fmt.Sprintf("%s > %s", d.ParentDependency.path(), d.Name)
+	}
+	return d.Name
+}
+
+// selectLibraryLocations groups the fetched dependencies by the library they depend on, and selects a single location
+// for every library fetched at more than one version. Selections for libraries fetched at a single version are carried
+// over from current. If offline is set, tags are listed from the dependency cache, rather than the remote repository.
+// The returned map is keyed by library key, and changed reports whether it differs from current.
+func selectLibraryLocations(ctx context.Context, nodes []libraryNode, current map[string]string, offline bool) (selected map[string]string, changed bool, err error) {
+	selected = map[string]string{}
+	for _, group := range groupLibraries(nodes) {
+		consistent := true
+		for _, n := range group[1:] {
+			if n.digest != group[0].digest {
+				consistent = false
+				break
+			}
+		}
+
+		if consistent {
+			for _, n := range group {
+				if location, ok := current[n.key]; ok {
+					selected[n.key] = location
+				}
+			}
+			continue
+		}
+
+		location, err := selectLocation(ctx, group, offline)
+		if err != nil {
+			return nil, false, err
+		}
+		if location == "" {
+			continue
+		}
+		for _, n := range group {
+			selected[n.key] = location
+		}
+	}
+
+	if len(selected) != len(current) {
+		return selected, true, nil
+	}
+	for k, v := range selected {
+		if current[k] != v {
+			return selected, true, nil
+		}
+	}
+	return selected, false, nil
+}
+
+// groupLibraries partitions nodes into groups of dependencies on the same library; either by location, or by declared
+// project name. Groups are sorted by dependency path.
+func groupLibraries(nodes []libraryNode) [][]libraryNode {
+	parents := make([]int, len(nodes))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	firstByIdentity := map[string]int{}
+	for i, n := range nodes {
+		for _, id := range n.identities() {
+			if j, ok := firstByIdentity[id]; ok {
+				parents[find(i)] = find(j)
+			} else {
+				firstByIdentity[id] = i
+			}
+		}
+	}
+
+	byRoot := map[int][]libraryNode{}
+	var roots []int
+	for i, n := range nodes {
+		root := find(i)
+		if _, ok := byRoot[root]; !ok {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], n)
+	}
+
+	groups := make([][]libraryNode, 0, len(roots))
+	for _, root := range roots {
+		group := byRoot[root]
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].dep.path() < group[j].dep.path()
+		})
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// selectLocation selects a single location for a group of dependencies on the same library.
+// For dependencies on the same git repository, the highest of the required tags is selected; or, if any dependency
+// declares a version constraint, the highest tag satisfying all constraints. Otherwise, the dependency with the highest
+// declared project version is selected.
+func selectLocation(ctx context.Context, group []libraryNode, offline bool) (string, error) {
+	sameRepository := strings.HasPrefix(group[0].key, "git:")
+	for _, n := range group[1:] {
+		if n.key != group[0].key {
+			sameRepository = false
+			break
+		}
+	}
+
+	if sameRepository {
+		return selectGitVersion(ctx, group, offline)
+	}
+	return selectProjectVersion(group)
+}
+
+type gitRequirement struct {
+	node       libraryNode
+	ref        gitRef
+	constraint string
+	version    *semver.Version
+}
+
+func selectGitVersion(ctx context.Context, group []libraryNode, offline bool) (string, error) {
+	_, subdir, err := group[0].dep.gitRepository()
+	if err != nil {
+		return "", err
+	}
+
+	var url string
+	var refs, minimums, constraints []gitRequirement
+	for _, n := range group {
+		u, ref, constraint, err := n.dep.gitRef()
+		if err != nil {
+			return "", err
+		}
+		if url == "" {
+			url = u
+		}
+
+		r := gitRequirement{node: n, ref: ref, constraint: constraint}
+		if constraint != "" {
+			constraints = append(constraints, r)
+		} else if ref.name != "" {
+			if ref.typ == "" || ref.typ == RefTypeTag {
+				r.version, _ = semver.NewVersion(ref.name)
+			}
+			if r.version != nil {
+				minimums = append(minimums, r)
+			} else {
+				refs = append(refs, r)
+			}
+		}
+	}
+
+	// Refs that aren't semantic versions, such as branches and commits, can't be compared, and must all be the same
+	if len(refs) > 0 {
+		var others []gitRequirement
+		others = append(others, refs[1:]...)
+		others = append(others, minimums...)
+		others = append(others, constraints...)
+		for _, r := range others {
+			if r.ref.name != refs[0].ref.name {
+				return "", conflictError(false, refs[0].node, r.node)
+			}
+		}
+		return gitLocation(url, subdir, refs[0].ref.name), nil
+	}
+
+	var minimum *gitRequirement
+	for i := range minimums {
+		if minimum == nil || minimums[i].version.GreaterThan(minimum.version) {
+			minimum = &minimums[i]
+		}
+	}
+	for _, r := range minimums {
+		if r.version.Major() != minimum.version.Major() {
+			return "", conflictError(false, r.node, minimum.node)
+		}
+	}
+
+	if len(constraints) == 0 {
+		if minimum == nil {
+			return "", nil
+		}
+		return gitLocation(url, subdir, minimum.ref.name), nil
+	}
+
+	tags, err := listGitTags(ctx, url, offline)
+	if err != nil {
+		return "", err
+	}
+
+	var selectedTag string
+	var selected *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if minimum != nil && (v.LessThan(minimum.version) || v.Major() != minimum.version.Major()) {
+			continue
+		}
+		matches := true
+		for _, r := range constraints {
+			if c, err := semver.NewConstraint(r.constraint); err != nil {
+				return "", fmt.Errorf("invalid version constraint '%s': %w", r.constraint, err)
+			} else if !c.Check(v) {
+				matches = false
+				break
+			}
+		}
+		if matches && (selected == nil || v.GreaterThan(selected)) {
+			selected = v
+			selectedTag = tag
+		}
+	}
+
+	if selected == nil {
+		return "", conflictError(false, group...)
+	}
+	return gitLocation(url, subdir, selectedTag), nil
+}
+
+func selectProjectVersion(group []libraryNode) (string, error) {
+	var selected *libraryNode
+	var selectedVersion *semver.Version
+	for i, n := range group {
+		v, err := semver.NewVersion(n.version)
+		if err != nil {
+			// Without a version, there is no way to tell which dependency to prefer
+			other := group[0]
+			if i == 0 {
+				other = group[1]
+			}
+			return "", conflictError(true, n, other)
+		}
+		if selected == nil || v.GreaterThan(selectedVersion) {
+			selected = &group[i]
+			selectedVersion = v
+		}
+	}
+
+	for _, n := range group {
+		if v, _ := semver.NewVersion(n.version); v.Major() != selectedVersion.Major() {
+			return "", conflictError(true, n, *selected)
+		}
+	}
+
+	return selected.fetched, nil
+}
+
+func conflictError(withProjectVersion bool, nodes ...libraryNode) error {
+	name := nodes[0].name
+	if name == "" {
+		name = nodes[0].key
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "cannot select a single version of library %s:", name)
+	for _, n := range nodes {
+		_, _ = fmt.Fprintf(&b, "\n  %s requires %s", n.dep.path(), n.requirement())
+		if withProjectVersion {
+			_, _ = fmt.Fprintf(&b, ", at project version '%s'", n.version)
+		}
+	}
+	return errors.New(b.String())
+}
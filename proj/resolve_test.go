@@ -0,0 +1,235 @@
+package proj
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDeduplicateResolution(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		revision := commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nversion := \"%s\"\n", tag))
+		tagCommit(t, repo, tag, revision)
+	}
+	repoLocation := fmt.Sprintf("git+file://%s", repoDir)
+
+	dependent := func(location string) string {
+		return fmt.Sprintf(`dependencies:
+  lib:
+    location: %s
+    namespace: false
+`, location)
+	}
+
+	tests := []struct {
+		note          string
+		resolution    string
+		files         map[string]string
+		expectedFiles map[string]string
+		expectedErr   []string
+		// allowOverlap is needed for versions of the same library, without namespace, to be materialized side by side
+		allowOverlap bool
+	}{
+		{
+			note:         "isolated resolution",
+			resolution:   ResolutionIsolated,
+			allowOverlap: true,
+			files: map[string]string{
+				"a/opa.project": dependent(repoLocation + "#v1.0.0"),
+				"b/opa.project": dependent(repoLocation + "#v1.1.0"),
+			},
+			expectedFiles: map[string]string{
+				filepath.Join(DepId("", repoLocation+"#v1.0.0"), "policy.rego"): "package lib\n\nversion := \"v1.0.0\"\n",
+				filepath.Join(DepId("", repoLocation+"#v1.1.0"), "policy.rego"): "package lib\n\nversion := \"v1.1.0\"\n",
+			},
+		},
+		{
+			note:       "highest required version",
+			resolution: ResolutionDeduplicate,
+			files: map[string]string{
+				"a/opa.project": dependent(repoLocation + "#v1.0.0"),
+				"b/opa.project": dependent(repoLocation + "#v1.1.0"),
+			},
+			expectedFiles: map[string]string{
+				filepath.Join(DepId("", repoLocation+"#v1.0.0"), "policy.rego"): "package lib\n\nversion := \"v1.1.0\"\n",
+				filepath.Join(DepId("", repoLocation+"#v1.1.0"), "policy.rego"): "package lib\n\nversion := \"v1.1.0\"\n",
+			},
+		},
+		{
+			note:       "version constraint and required version",
+			resolution: ResolutionDeduplicate,
+			files: map[string]string{
+				"a/opa.project": dependent(repoLocation + "#^1.0.0"),
+				"b/opa.project": dependent(repoLocation + "#v1.0.0"),
+			},
+			expectedFiles: map[string]string{
+				filepath.Join(DepId("", repoLocation+"#^1.0.0"), "policy.rego"): "package lib\n\nversion := \"v1.1.0\"\n",
+				filepath.Join(DepId("", repoLocation+"#v1.0.0"), "policy.rego"): "package lib\n\nversion := \"v1.1.0\"\n",
+			},
+		},
+		{
+			note:       "incompatible major versions",
+			resolution: ResolutionDeduplicate,
+			files: map[string]string{
+				"a/opa.project": dependent(repoLocation + "#v1.0.0"),
+				"b/opa.project": dependent(repoLocation + "#v2.0.0"),
+			},
+			expectedErr: []string{
+				"cannot select a single version of library git:" + strings.TrimPrefix(repoLocation, "git+"),
+				"a > lib requires " + repoLocation + "#v1.0.0",
+				"b > lib requires " + repoLocation + "#v2.0.0",
+			},
+		},
+		{
+			note:       "same project name",
+			resolution: ResolutionDeduplicate,
+			files: map[string]string{
+				"a/opa.project":    dependent("file:/lib1"),
+				"b/opa.project":    dependent("file:/lib2"),
+				"lib1/opa.project": "name: lib\nversion: 1.0.0\n",
+				"lib1/policy.rego": "package lib\n\nversion := \"1.0.0\"\n",
+				"lib2/opa.project": "name: lib\nversion: 1.2.0\n",
+				"lib2/policy.rego": "package lib\n\nversion := \"1.2.0\"\n",
+			},
+			expectedFiles: map[string]string{
+				filepath.Join(DepId("", "file:/lib1"), "policy.rego"): "package lib\n\nversion := \"1.2.0\"\n",
+				filepath.Join(DepId("", "file:/lib2"), "policy.rego"): "package lib\n\nversion := \"1.2.0\"\n",
+			},
+		},
+		{
+			note:       "same project name without version",
+			resolution: ResolutionDeduplicate,
+			files: map[string]string{
+				"a/opa.project":    dependent("file:/lib1"),
+				"b/opa.project":    dependent("file:/lib2"),
+				"lib1/opa.project": "name: lib\nversion: 1.0.0\n",
+				"lib2/opa.project": "name: lib\n",
+			},
+			expectedErr: []string{
+				"cannot select a single version of library lib",
+				"a > lib requires file:/lib1, at project version '1.0.0'",
+				"b > lib requires file:/lib2, at project version ''",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": fmt.Sprintf(`resolution: %s
+dependencies:
+  a:
+    location: file:/a
+    namespace: false
+  b:
+    location: file:/b
+    namespace: false
+`, tc.resolution),
+			}
+			for k, v := range tc.files {
+				files[k] = v
+			}
+
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = project.Update(UpdateOptions{AllowOverlap: tc.allowOverlap})
+				if len(tc.expectedErr) > 0 {
+					if err == nil {
+						t.Fatalf("expected error, got none")
+					}
+					for _, expected := range tc.expectedErr {
+						if !strings.Contains(err.Error(), expected) {
+							t.Fatalf("expected error containing '%s', got:\n\n%v", expected, err)
+						}
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for file, expected := range tc.expectedFiles {
+					expectFileContent(t, filepath.Join(path, ".opa", "dependencies", file), expected)
+				}
+
+				// The lock file reproduces the selected versions
+				if err := project.Update(UpdateOptions{Frozen: true, AllowOverlap: tc.allowOverlap}); err != nil {
+					t.Fatal(err)
+				}
+				for file, expected := range tc.expectedFiles {
+					expectFileContent(t, filepath.Join(path, ".opa", "dependencies", file), expected)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeduplicateResolutionOnlyRefetchesChangedLibraries(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	for _, tag := range []string{"v1.0.0", "v1.1.0"} {
+		revision := commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nversion := \"%s\"\n", tag))
+		tagCommit(t, repo, tag, revision)
+	}
+	repoLocation := fmt.Sprintf("git+file://%s", repoDir)
+
+	archive := tarGzArchive(t, map[string]string{"other.rego": "package other\n"})
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	files := map[string]string{
+		"opa.project": fmt.Sprintf(`resolution: deduplicate
+dependencies:
+  a: file:/a
+  b: file:/b
+  other: %s/other.tar.gz
+`, server.URL),
+		"a/opa.project": fmt.Sprintf("dependencies:\n  lib: %s#v1.0.0\n", repoLocation),
+		"b/opa.project": fmt.Sprintf("dependencies:\n  lib: %s#v1.1.0\n", repoLocation),
+	}
+	err := withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Selecting a single version of lib takes a second round, in which the unrelated archive isn't downloaded again
+		mu.Lock()
+		defer mu.Unlock()
+		if requests != 1 {
+			t.Fatalf("expected archive to be downloaded once, got %d requests", requests)
+		}
+		lib := Dependency{
+			DependencyInfo:   DependencyInfo{Location: repoLocation + "#v1.0.0", Namespace: "lib"},
+			ParentDependency: &Dependency{DependencyInfo: DependencyInfo{Location: "file:/a", Namespace: "a"}},
+		}
+		expectFileContent(t, filepath.Join(lib.dir(dependenciesDir(path)), "policy.rego"),
+			"package a.lib.lib\n\nversion := \"v1.1.0\"\n")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"github.com/johanfylling/odm/printer"
+	"github.com/johanfylling/odm/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stateFileExt is the extension of the state file kept next to every dependency directory. It's not loaded by OPA.
+const stateFileExt = ".state"
+
+// dependencyState records what a dependency directory was materialized from, so that later updates can keep the
+// directory, rather than fetching and namespacing the dependency again, as long as it hasn't changed.
+type dependencyState struct {
+	// Location, Path, and Namespace are the location the dependency was fetched from, and its full namespace
+	Location  string `yaml:"location"`
+	Path      string `yaml:"path,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Ref       string `yaml:"ref,omitempty"`
+	RefType   string `yaml:"ref_type,omitempty"`
+	Revision  string `yaml:"revision"`
+	// Digest is the digest of the dependency content, as fetched
+	Digest string `yaml:"digest"`
+	// Materialized is the digest of the dependency directory, after namespacing
+	Materialized string `yaml:"materialized"`
+}
+
+func stateFilePath(depsRootDir, id string) string {
+	return filepath.Join(depsRootDir, id+stateFileExt)
+}
+
+// readDependencyState reads the state of the dependency directory with the given id.
+// nil is returned if there is no state, or it can't be read; in which case the directory must be materialized again.
+func readDependencyState(ctx context.Context, depsRootDir, id string) *dependencyState {
+	data, err := os.ReadFile(stateFilePath(depsRootDir, id))
+	if err != nil {
+		return nil
+	}
+
+	var state dependencyState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		printer.From(ctx).Debug("Ignoring invalid dependency state for %s: %s", id, err)
+		return nil
+	}
+	return &state
+}
+
+func writeDependencyState(depsRootDir, id string, state dependencyState) error {
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dependency state: %w", err)
+	}
+	if err := os.WriteFile(stateFilePath(depsRootDir, id), data, 0644); err != nil {
+		return fmt.Errorf("failed to write dependency state: %w", err)
+	}
+	return nil
+}
+
+func removeDependencyState(depsRootDir, id string) error {
+	if err := os.Remove(stateFilePath(depsRootDir, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove dependency state: %w", err)
+	}
+	return nil
+}
+
+// keeps reports whether the dependency directory dir, materialized as recorded by the state, can be kept for a
+// dependency fetched from location and path, with the given full namespace, at revision.
+// The directory must not have been modified since it was materialized.
+func (s *dependencyState) keeps(ctx context.Context, dir, location, path, namespace, revision string) bool {
+	if s == nil || s.Location != location || s.Path != path || s.Namespace != namespace || s.Revision != revision {
+		return false
+	}
+
+	digest, err := utils.HashDir(dir, nil)
+	if err != nil {
+		return false
+	}
+	if digest != s.Materialized {
+		printer.From(ctx).Debug("Dependency directory %s has been modified", dir)
+		return false
+	}
+	return true
+}
+
+// pruneDependencies removes all dependency directories, and their state files, in depsRootDir that aren't among the
+// given dependency ids.
+func pruneDependencies(depsRootDir string, ids map[string]bool) error {
+	entries, err := os.ReadDir(depsRootDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read dependency directory %s: %w", depsRootDir, err)
+	}
+
+	for _, entry := range entries {
+		if ids[strings.TrimSuffix(entry.Name(), stateFileExt)] {
+			continue
+		}
+		printer.Debug("Removing orphaned dependency %s", entry.Name())
+		if err := os.RemoveAll(filepath.Join(depsRootDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove orphaned dependency %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
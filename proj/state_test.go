@@ -0,0 +1,152 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncrementalUpdate(t *testing.T) {
+	tests := []struct {
+		note string
+		// modify is applied between the first and the second update; with the remote repository removed, unless
+		// the dependency is expected to be materialized again
+		modify          func(t *testing.T, path, depDir string)
+		keepRemote      bool
+		expectedContent string
+		expectOrphan    bool
+	}{
+		{
+			note:            "unchanged dependencies kept",
+			modify:          func(*testing.T, string, string) {},
+			expectedContent: "package lib.policy\n",
+		},
+		{
+			note: "modified dependency directory materialized again",
+			modify: func(t *testing.T, path, depDir string) {
+				if err := os.WriteFile(filepath.Join(depDir, "policy.rego"), []byte("package tampered\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			keepRemote:      true,
+			expectedContent: "package lib.policy\n",
+		},
+		{
+			note: "undeclared dependency removed",
+			modify: func(t *testing.T, path, depDir string) {
+				if err := os.WriteFile(filepath.Join(path, "opa.project"), []byte("name: test\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expectOrphan: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			cache := t.TempDir()
+			t.Setenv(cacheDirEnv, cache)
+
+			repoDir := t.TempDir()
+			repo := initGitRepo(t, repoDir)
+			tagCommit(t, repo, "v1", commitFile(t, repo, "policy.rego", "package policy\n"))
+
+			files := map[string]string{
+				"opa.project": fmt.Sprintf("dependencies:\n  lib: git+file://%s#v1\n", repoDir),
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				depsDir := dependenciesDir(path)
+				depDir := project.Dependencies["lib"].dir(depsDir)
+
+				tc.modify(t, path, depDir)
+				if !tc.keepRemote {
+					// Nothing can be fetched, so the dependency directory must be kept as it is
+					if err := os.RemoveAll(repoDir); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.RemoveAll(cache); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				project, err = ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := project.Update(UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+
+				if tc.expectOrphan {
+					entries, err := os.ReadDir(depsDir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(entries) != 0 {
+						t.Fatalf("expected orphaned dependency to be removed, got %v", entries)
+					}
+					return
+				}
+				expectFileContent(t, filepath.Join(depDir, "policy.rego"), tc.expectedContent)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestIncrementalUpdateLocalDependency(t *testing.T) {
+	files := map[string]string{
+		"opa.project": `dependencies:
+  lib:
+    location: file:/lib
+    namespace: false
+`,
+		"lib/policy.rego": "package lib\n\nx := 1\n",
+	}
+	err := withTempFiles(files, func(path string) {
+		project, err := ReadProjectFromFile(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		depDir := project.Dependencies["lib"].dir(dependenciesDir(path))
+		state := readDependencyState(context.Background(), dependenciesDir(path), project.Dependencies["lib"].id())
+		if state == nil {
+			t.Fatal("expected dependency state to be written")
+		}
+
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if kept := readDependencyState(context.Background(), dependenciesDir(path), project.Dependencies["lib"].id()); *kept != *state {
+			t.Fatalf("expected dependency state %v to be kept, got %v", state, kept)
+		}
+
+		if err := os.WriteFile(filepath.Join(path, "lib", "policy.rego"), []byte("package lib\n\nx := 2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := project.Update(UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		expectFileContent(t, filepath.Join(depDir, "policy.rego"), "package lib\n\nx := 2\n")
+		if changed := readDependencyState(context.Background(), dependenciesDir(path), project.Dependencies["lib"].id()); changed.Revision == state.Revision {
+			t.Fatalf("expected revision of changed dependency to differ from %s", state.Revision)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,162 @@
+package proj
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TreeNode is a project, or one of its dependencies, in the dependency tree of a project.
+type TreeNode struct {
+	Name string `json:"name"`
+	// Location is the declared location of the dependency, and Resolved the location actually fetched, if different
+	Location string `json:"location,omitempty"`
+	Resolved string `json:"resolved,omitempty"`
+	// Revision is the revision the dependency is locked at; the git commit SHA, or the file tree hash of local
+	// dependencies
+	Revision string `json:"revision,omitempty"`
+	// Namespace is the full namespace the dependency is mounted at; empty if not namespaced
+	Namespace    string      `json:"namespace,omitempty"`
+	Dependencies []*TreeNode `json:"dependencies,omitempty"`
+
+	id string
+}
+
+// Tree returns the dependency tree of the loaded project, with dependencies sorted by name.
+// Resolved revisions are read from the project's lock file. If depth is positive, dependencies further than depth
+// levels from the project are left out.
+func (p *Project) Tree(depth int) (*TreeNode, error) {
+	lock, err := ReadLockFromFile(p.LockFilePath())
+	if err != nil {
+		return nil, err
+	}
+
+	name := p.Name
+	if name == "" {
+		name = "root"
+	}
+	root := &TreeNode{Name: name}
+	root.Dependencies = treeNodes(p, lock, depth, 1)
+	return root, nil
+}
+
+func treeNodes(p *Project, lock *Lock, depth int, level int) []*TreeNode {
+	if p == nil || (depth > 0 && level > depth) {
+		return nil
+	}
+
+	names := p.dependencyNames()
+	nodes := make([]*TreeNode, 0, len(names))
+	for _, name := range names {
+		dep := p.Dependencies[name]
+		node := &TreeNode{
+			Name:      name,
+			Location:  dep.Location,
+			Namespace: dep.fullNamespace(),
+			id:        dep.id(),
+		}
+		if locked := lock.find(node.id); locked != nil {
+			node.Resolved = locked.Resolved
+			node.Revision = locked.Revision
+		}
+		node.Dependencies = treeNodes(dep.Project, lock, depth, level+1)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// WriteText writes the tree as indented text, one node per line.
+func (n *TreeNode) WriteText(w io.Writer) error {
+	return n.writeText(w, 0)
+}
+
+func (n *TreeNode) writeText(w io.Writer, indent int) error {
+	line := strings.Repeat("  ", indent) + n.Name
+	if n.Location != "" {
+		line += " " + n.location()
+	}
+	if n.Revision != "" {
+		line += " @ " + shortRevision(n.Revision)
+	}
+	if n.Namespace != "" {
+		line += fmt.Sprintf(" (data.%s)", n.Namespace)
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	for _, dep := range n.Dependencies {
+		if err := dep.writeText(w, indent+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the tree as a JSON document.
+func (n *TreeNode) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dependency tree: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// WriteDot writes the tree as a Graphviz graph. Dependencies sharing a dependency directory are a single node.
+func (n *TreeNode) WriteDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	fmt.Fprintf(&b, "  %q [label=%q];\n", "root", n.Name)
+
+	written := map[string]bool{}
+	edges := map[string]bool{}
+	var walk func(parentId string, deps []*TreeNode)
+	walk = func(parentId string, deps []*TreeNode) {
+		for _, dep := range deps {
+			if !written[dep.id] {
+				written[dep.id] = true
+				label := []string{dep.Name, dep.location()}
+				if dep.Revision != "" {
+					label = append(label, shortRevision(dep.Revision))
+				}
+				if dep.Namespace != "" {
+					label = append(label, "data."+dep.Namespace)
+				}
+				fmt.Fprintf(&b, "  %q [label=%q];\n", dep.id, strings.Join(label, "\n"))
+			}
+			if edge := parentId + " -> " + dep.id; !edges[edge] {
+				edges[edge] = true
+				fmt.Fprintf(&b, "  %q -> %q;\n", parentId, dep.id)
+			}
+			walk(dep.id, dep.Dependencies)
+		}
+	}
+	walk("root", n.Dependencies)
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (n *TreeNode) location() string {
+	if n.Resolved != "" {
+		return fmt.Sprintf("%s -> %s", n.Location, n.Resolved)
+	}
+	return n.Location
+}
+
+// shortRevision abbreviates commit SHAs, and file tree hashes, for display.
+func shortRevision(revision string) string {
+	if algorithm, hash, ok := strings.Cut(revision, ":"); ok {
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		return algorithm + ":" + hash
+	}
+	if commitPattern.MatchString(revision) && len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
@@ -0,0 +1,138 @@
+package proj
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	digest := "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	depA := DepId("a", "git+https://example.com/a.git#v1")
+	depB := DepId("b", "file:/b")
+	depB1 := DepId("b.b1", "file:/b1")
+
+	files := map[string]string{
+		"opa.project": `name: proj
+dependencies:
+  b: file:/b
+  a: git+https://example.com/a.git#v1
+`,
+		lockFileName: `dependencies:
+    - name: a
+      location: git+https://example.com/a.git#v1
+      namespace: a
+      requested: v1
+      ref: v1
+      ref_type: tag
+      revision: ` + commit + `
+      digest: ` + digest + `
+    - name: b
+      location: file:/b
+      namespace: b
+      revision: ` + digest + `
+      digest: ` + digest + `
+`,
+		filepath.Join(".opa", "dependencies", depA, "policy.rego"): `package a`,
+		filepath.Join(".opa", "dependencies", depB, "opa.project"): `dependencies:
+  b1: file:/b1`,
+		filepath.Join(".opa", "dependencies", depB1, "policy.rego"): `package b1`,
+	}
+
+	tests := []struct {
+		note     string
+		depth    int
+		write    func(*TreeNode, io.Writer) error
+		expected string
+	}{
+		{
+			note:  "text",
+			write: (*TreeNode).WriteText,
+			expected: `proj
+  a git+https://example.com/a.git#v1 @ 0123456789ab (data.a)
+  b file:/b @ sha256:fedcba987654 (data.b)
+    b1 file:/b1 (data.b.b1)
+`,
+		},
+		{
+			note:  "text with depth",
+			depth: 1,
+			write: (*TreeNode).WriteText,
+			expected: `proj
+  a git+https://example.com/a.git#v1 @ 0123456789ab (data.a)
+  b file:/b @ sha256:fedcba987654 (data.b)
+`,
+		},
+		{
+			note:  "json",
+			write: (*TreeNode).WriteJSON,
+			expected: `{
+  "name": "proj",
+  "dependencies": [
+    {
+      "name": "a",
+      "location": "git+https://example.com/a.git#v1",
+      "revision": "` + commit + `",
+      "namespace": "a"
+    },
+    {
+      "name": "b",
+      "location": "file:/b",
+      "revision": "` + digest + `",
+      "namespace": "b",
+      "dependencies": [
+        {
+          "name": "b1",
+          "location": "file:/b1",
+          "namespace": "b.b1"
+        }
+      ]
+    }
+  ]
+}
+`,
+		},
+		{
+			note:  "dot",
+			write: (*TreeNode).WriteDot,
+			expected: `digraph dependencies {
+  "root" [label="proj"];
+  "` + depA + `" [label="a\ngit+https://example.com/a.git#v1\n0123456789ab\ndata.a"];
+  "root" -> "` + depA + `";
+  "` + depB + `" [label="b\nfile:/b\nsha256:fedcba987654\ndata.b"];
+  "root" -> "` + depB + `";
+  "` + depB1 + `" [label="b1\nfile:/b1\ndata.b.b1"];
+  "` + depB + `" -> "` + depB1 + `";
+}
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadAndLoadProject(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tree, err := project.Tree(tc.depth)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var buf bytes.Buffer
+				if err := tc.write(tree, &buf); err != nil {
+					t.Fatal(err)
+				}
+				if actual := buf.String(); actual != tc.expected {
+					t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
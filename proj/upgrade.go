@@ -0,0 +1,185 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"github.com/johanfylling/odm/utils"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+type UpgradeOptions struct {
+	// Names are the dependencies to upgrade; all dependencies pinned at a tag, if empty.
+	Names []string
+	// Compatible upgrades to the latest tag with the same major version, rather than the latest tag.
+	Compatible bool
+	// To is the tag to upgrade to, rather than the latest one.
+	To string
+	// Offline lists tags from the dependency cache, rather than from remote repositories.
+	Offline bool
+}
+
+// Upgrade changes the tag a git dependency declared in opa.project is pinned at.
+type Upgrade struct {
+	Name string
+	From string
+	To   string
+}
+
+// PlanUpgrades returns the upgrades of the project's git dependencies, pinned at a tag, to the tag selected by opts.
+// Dependencies already at the selected tag, or at a newer one, aren't upgraded. Dependencies declaring a version
+// constraint aren't pinned at a tag, and aren't upgraded; they stay at the tag recorded in the lock file until it's
+// re-resolved to the newest matching tag by an update with Refresh.
+func (p *Project) PlanUpgrades(ctx context.Context, opts UpgradeOptions) ([]Upgrade, error) {
+	names := opts.Names
+	explicit := len(names) > 0
+	if !explicit {
+		for _, name := range p.dependencyNames() {
+			if strings.HasPrefix(p.Dependencies[name].Location, "git+") {
+				names = append(names, name)
+			}
+		}
+	}
+	if opts.To != "" && len(names) != 1 {
+		return nil, fmt.Errorf("a single dependency must be named when upgrading to a given tag")
+	}
+
+	var upgrades []Upgrade
+	for _, name := range names {
+		dep, ok := p.Dependencies[name]
+		if !ok {
+			return nil, fmt.Errorf("dependency %s not found in project", name)
+		}
+		if !strings.HasPrefix(dep.Location, "git+") {
+			return nil, fmt.Errorf("dependency %s is not a git dependency", name)
+		}
+
+		url, ref, constraint, err := dep.gitRef()
+		if err != nil {
+			return nil, err
+		}
+		if constraint != "" && explicit {
+			return nil, fmt.Errorf("dependency %s declares version constraint %s; run 'odm update --refresh' to move it to the newest matching tag",
+				name, constraint)
+		}
+		if constraint != "" || ref.name == "" || (ref.typ != "" && ref.typ != RefTypeTag) {
+			if explicit {
+				return nil, fmt.Errorf("dependency %s is not pinned at a tag", name)
+			}
+			continue
+		}
+
+		tags, err := listGitTags(ctx, url, opts.Offline)
+		if err != nil {
+			return nil, err
+		}
+		if !utils.Contains(tags, ref.name) {
+			// A ref declared in the location may just as well be a branch
+			if explicit {
+				return nil, fmt.Errorf("dependency %s is not pinned at a tag", name)
+			}
+			continue
+		}
+
+		target, err := selectUpgrade(ref.name, tags, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade dependency %s: %w", name, err)
+		}
+		if target != "" && target != ref.name {
+			upgrades = append(upgrades, Upgrade{Name: name, From: ref.name, To: target})
+		}
+	}
+	return upgrades, nil
+}
+
+// selectUpgrade selects the tag to upgrade from the current tag to; or an empty string if there is no newer tag.
+func selectUpgrade(current string, tags []string, opts UpgradeOptions) (string, error) {
+	if opts.To != "" {
+		if !utils.Contains(tags, opts.To) {
+			return "", fmt.Errorf("tag '%s' not found", opts.To)
+		}
+		return opts.To, nil
+	}
+
+	v, err := semver.NewVersion(current)
+	if err != nil {
+		return "", fmt.Errorf("current tag '%s' is not a semantic version", current)
+	}
+
+	var target string
+	if opts.Compatible {
+		if target, err = selectVersion(fmt.Sprintf("^%s", v), tags); err != nil {
+			return "", err
+		}
+	} else {
+		target = latestVersion(tags)
+	}
+
+	if t, err := semver.NewVersion(target); err != nil || !t.GreaterThan(v) {
+		return "", nil
+	}
+	return target, nil
+}
+
+// UpgradeProjectFile applies upgrades to the content of a project file, and returns the new content.
+// Only the tags are rewritten, in place; the rest of the file, including comments, ordering, and quoting, is left as is.
+func UpgradeProjectFile(data []byte, upgrades []Upgrade) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse project file: %w", err)
+	}
+
+	// Edits are applied last to first, so that earlier positions stay valid
+	var edits []scalarEdit
+	for _, u := range upgrades {
+		node := mappingValue(mappingValue(documentRoot(&doc), "dependencies"), u.Name)
+		if node == nil {
+			return nil, fmt.Errorf("dependency %s not found in project file", u.Name)
+		}
+
+		edit, err := upgradeEdit(node, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade dependency %s: %w", u.Name, err)
+		}
+		edits = append(edits, edit)
+	}
+
+	for len(edits) > 0 {
+		last := 0
+		for i, e := range edits {
+			if e.node.Line > edits[last].node.Line ||
+				(e.node.Line == edits[last].node.Line && e.node.Column > edits[last].node.Column) {
+				last = i
+			}
+		}
+		var err error
+		if data, err = replaceScalar(data, edits[last].node, edits[last].value); err != nil {
+			return nil, err
+		}
+		edits = append(edits[:last], edits[last+1:]...)
+	}
+	return data, nil
+}
+
+type scalarEdit struct {
+	node  *yaml.Node
+	value string
+}
+
+// upgradeEdit returns the edit of the scalar declaring the tag of the dependency node, in either its short or long
+// form.
+func upgradeEdit(node *yaml.Node, u Upgrade) (scalarEdit, error) {
+	if node.Kind == yaml.MappingNode {
+		for _, key := range []string{"tag", "ref"} {
+			if ref := mappingValue(node, key); ref != nil && ref.Value == u.From {
+				return scalarEdit{node: ref, value: u.To}, nil
+			}
+		}
+		node = mappingValue(node, "location")
+	}
+	if node == nil || node.Kind != yaml.ScalarNode || !strings.HasSuffix(node.Value, "#"+u.From) {
+		return scalarEdit{}, fmt.Errorf("tag '%s' not declared", u.From)
+	}
+	return scalarEdit{node: node, value: strings.TrimSuffix(node.Value, u.From) + u.To}, nil
+}
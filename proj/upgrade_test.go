@@ -0,0 +1,211 @@
+package proj
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeProjectFile(t *testing.T) {
+	tests := []struct {
+		note        string
+		project     string
+		upgrades    []Upgrade
+		expected    string
+		expectedErr string
+	}{
+		{
+			note: "short form",
+			project: `# Policies shared by all services
+name: services
+
+dependencies:
+  # Pinned until the next release
+  lib: git+https://example.com/lib.git#v1.0.0   # trailing comment
+  local: file:/../local
+`,
+			upgrades: []Upgrade{{Name: "lib", From: "v1.0.0", To: "v1.10.0"}},
+			expected: `# Policies shared by all services
+name: services
+
+dependencies:
+  # Pinned until the next release
+  lib: git+https://example.com/lib.git#v1.10.0   # trailing comment
+  local: file:/../local
+`,
+		},
+		{
+			note: "long form",
+			project: `dependencies:
+  lib:
+    namespace: shared
+    location: "git+https://example.com/lib.git//policies#v1.0.0"
+  other:
+    location: git+https://example.com/other.git
+    tag: 'v0.1.0'
+`,
+			upgrades: []Upgrade{
+				{Name: "other", From: "v0.1.0", To: "v0.2.0"},
+				{Name: "lib", From: "v1.0.0", To: "v2.0.0"},
+			},
+			expected: `dependencies:
+  lib:
+    namespace: shared
+    location: "git+https://example.com/lib.git//policies#v2.0.0"
+  other:
+    location: git+https://example.com/other.git
+    tag: 'v0.2.0'
+`,
+		},
+		{
+			note: "multiple dependencies on one line",
+			project: `dependencies: {a: "git+https://example.com/a.git#v1", b: "git+https://example.com/b.git#v1"}
+`,
+			upgrades: []Upgrade{
+				{Name: "a", From: "v1", To: "v1.0.1"},
+				{Name: "b", From: "v1", To: "v2"},
+			},
+			expected: `dependencies: {a: "git+https://example.com/a.git#v1.0.1", b: "git+https://example.com/b.git#v2"}
+`,
+		},
+		{
+			note: "tag not declared",
+			project: `dependencies:
+  lib: git+https://example.com/lib.git#v1.0.0
+`,
+			upgrades:    []Upgrade{{Name: "lib", From: "v0.9.0", To: "v1.1.0"}},
+			expectedErr: "failed to upgrade dependency lib: tag 'v0.9.0' not declared",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			actual, err := UpgradeProjectFile([]byte(tc.project), tc.upgrades)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlanUpgrades(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0", "v3.0.0-rc1"} {
+		tagCommit(t, repo, tag, commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nversion := \"%s\"\n", tag)))
+	}
+	location := fmt.Sprintf("git+file://%s", repoDir)
+
+	project := `dependencies:
+  pinned: %[1]s#v1.0.0
+  tagged:
+    location: %[1]s
+    tag: v1.1.0
+  latest: %[1]s#v2.0.0
+  branch: %[1]s#master
+  constrained: %[1]s#^1.0.0
+  local: file:/local
+`
+
+	tests := []struct {
+		note        string
+		opts        UpgradeOptions
+		expected    []Upgrade
+		expectedErr string
+	}{
+		{
+			note: "latest",
+			expected: []Upgrade{
+				{Name: "pinned", From: "v1.0.0", To: "v2.0.0"},
+				{Name: "tagged", From: "v1.1.0", To: "v2.0.0"},
+			},
+		},
+		{
+			note: "compatible",
+			opts: UpgradeOptions{Compatible: true},
+			expected: []Upgrade{
+				{Name: "pinned", From: "v1.0.0", To: "v1.1.0"},
+			},
+		},
+		{
+			note: "named",
+			opts: UpgradeOptions{Names: []string{"tagged"}},
+			expected: []Upgrade{
+				{Name: "tagged", From: "v1.1.0", To: "v2.0.0"},
+			},
+		},
+		{
+			note: "to tag",
+			opts: UpgradeOptions{Names: []string{"latest"}, To: "v1.1.0"},
+			expected: []Upgrade{
+				{Name: "latest", From: "v2.0.0", To: "v1.1.0"},
+			},
+		},
+		{
+			note:        "to unknown tag",
+			opts:        UpgradeOptions{Names: []string{"pinned"}, To: "v9.0.0"},
+			expectedErr: "failed to upgrade dependency pinned: tag 'v9.0.0' not found",
+		},
+		{
+			note:        "to tag without name",
+			opts:        UpgradeOptions{To: "v1.1.0"},
+			expectedErr: "a single dependency must be named when upgrading to a given tag",
+		},
+		{
+			note:        "named branch",
+			opts:        UpgradeOptions{Names: []string{"branch"}},
+			expectedErr: "dependency branch is not pinned at a tag",
+		},
+		{
+			note:        "named constrained dependency",
+			opts:        UpgradeOptions{Names: []string{"constrained"}},
+			expectedErr: "dependency constrained declares version constraint ^1.0.0; run 'odm update --refresh'",
+		},
+		{
+			note:        "named local dependency",
+			opts:        UpgradeOptions{Names: []string{"local"}},
+			expectedErr: "dependency local is not a git dependency",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": fmt.Sprintf(project, location),
+			}
+			err := withTempFiles(files, func(path string) {
+				p, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := p.PlanUpgrades(context.Background(), tc.opts)
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(actual, tc.expected) {
+					t.Fatalf("expected %v, got %v", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
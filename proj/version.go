@@ -0,0 +1,58 @@
+package proj
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"strings"
+)
+
+// isVersionConstraint reports whether ref is a semantic version range, such as '^1.2.0' or '>=1.4, <2',
+// rather than a literal git ref.
+func isVersionConstraint(ref string) bool {
+	return strings.ContainsAny(ref, "^~<>=*|, ")
+}
+
+// selectVersion returns the tag with the highest semantic version satisfying constraint.
+// Tags that aren't semantic versions are ignored.
+func selectVersion(constraint string, tags []string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint '%s': %w", constraint, err)
+	}
+
+	var selectedTag string
+	var selected *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if c.Check(v) && (selected == nil || v.GreaterThan(selected)) {
+			selected = v
+			selectedTag = tag
+		}
+	}
+
+	if selected == nil {
+		return "", fmt.Errorf("no tag matches version constraint '%s'", constraint)
+	}
+	return selectedTag, nil
+}
+
+// latestVersion returns the tag with the highest semantic version that isn't a pre-release, or an empty string if
+// there is none.
+func latestVersion(tags []string) string {
+	var latestTag string
+	var latest *semver.Version
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil || v.Prerelease() != "" {
+			continue
+		}
+		if latest == nil || v.GreaterThan(latest) {
+			latest = v
+			latestTag = tag
+		}
+	}
+	return latestTag
+}
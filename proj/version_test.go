@@ -0,0 +1,127 @@
+package proj
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSelectVersion(t *testing.T) {
+	tags := []string{"v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0-rc1", "v1.4.0", "v2.0.0", "latest", "1.5.0"}
+
+	tests := []struct {
+		constraint  string
+		expected    string
+		expectedErr string
+	}{
+		{constraint: "^1.2.0", expected: "1.5.0"},
+		{constraint: "~1.2.0", expected: "v1.2.5"},
+		{constraint: ">=1.4, <2", expected: "1.5.0"},
+		{constraint: ">=1.0, <1.4", expected: "v1.2.5"},
+		{constraint: "*", expected: "v2.0.0"},
+		{constraint: "^3.0.0", expectedErr: "no tag matches version constraint '^3.0.0'"},
+		{constraint: ">>1", expectedErr: "invalid version constraint '>>1'"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.constraint, func(t *testing.T) {
+			actual, err := selectVersion(tc.constraint, tags)
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected tag %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUpdateResolvesVersionConstraint(t *testing.T) {
+	repoDir := t.TempDir()
+	repo := initGitRepo(t, repoDir)
+	revisions := map[string]string{}
+	for i, tag := range []string{"v1.0.0", "v1.2.0", "v1.3.1", "v2.0.0"} {
+		revisions[tag] = commitFile(t, repo, "policy.rego", fmt.Sprintf("package lib\n\nx := %d\n", i))
+		tagCommit(t, repo, tag, revisions[tag])
+	}
+
+	tests := []struct {
+		note        string
+		dependency  string
+		expectedTag string
+		expectedErr string
+	}{
+		{
+			note: "constraint in location",
+			dependency: fmt.Sprintf(`
+    location: git+file://%s#^1.2.0`, repoDir),
+			expectedTag: "v1.3.1",
+		},
+		{
+			note: "version attribute",
+			dependency: fmt.Sprintf(`
+    location: git+file://%s
+    version: ">=1.0, <1.3"`, repoDir),
+			expectedTag: "v1.2.0",
+		},
+		{
+			note: "no matching tag",
+			dependency: fmt.Sprintf(`
+    location: git+file://%s
+    version: ^3`, repoDir),
+			expectedErr: "no tag matches version constraint '^3'",
+		},
+		{
+			note: "both ref and version",
+			dependency: fmt.Sprintf(`
+    location: git+file://%s#v1.0.0
+    version: ^1`, repoDir),
+			expectedErr: "dependency lib declares conflicting git refs: location ref, version",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"opa.project": fmt.Sprintf("dependencies:\n  lib:%s\n    namespace: false\n", tc.dependency),
+			}
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadProjectFromFile(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = project.Update(UpdateOptions{})
+				if tc.expectedErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+						t.Fatalf("expected error containing '%s', got %v", tc.expectedErr, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				lock, err := ReadLockFromFile(project.LockFilePath())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(lock.Dependencies) != 1 {
+					t.Fatalf("expected exactly one locked dependency, got %v", lock.Dependencies)
+				}
+				if locked := lock.Dependencies[0]; locked.Ref != tc.expectedTag || locked.Revision != revisions[tc.expectedTag] {
+					t.Fatalf("expected dependency locked at %s (%s), got %s (%s)",
+						tc.expectedTag, revisions[tc.expectedTag], locked.Ref, locked.Revision)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package proj
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DependencyPath is a chain of dependencies, from a direct dependency of the project to a transitive dependency.
+type DependencyPath []Dependency
+
+// FindPaths returns every path from the loaded project to dependencies matching query, sorted by dependency names.
+// query matches dependencies by name, or by full namespace, with or without the 'data.' prefix. A query naming a
+// package within a dependency's namespace, such as 'data.lib.policy', matches the dependencies with the most specific
+// namespace containing it.
+func (p *Project) FindPaths(query string) []DependencyPath {
+	namespace := strings.TrimPrefix(query, "data.")
+
+	var exact, within []DependencyPath
+	longest := 0
+	var walk func(p *Project, parent DependencyPath)
+	walk = func(p *Project, parent DependencyPath) {
+		if p == nil {
+			return
+		}
+		for _, name := range p.dependencyNames() {
+			dep := p.Dependencies[name]
+			path := append(append(DependencyPath{}, parent...), dep)
+
+			fullNamespace := dep.fullNamespace()
+			switch {
+			case dep.Name == query || (fullNamespace != "" && fullNamespace == namespace):
+				exact = append(exact, path)
+			case fullNamespace != "" && strings.HasPrefix(namespace, fullNamespace+"."):
+				if len(fullNamespace) > longest {
+					longest = len(fullNamespace)
+					within = nil
+				}
+				if len(fullNamespace) == longest {
+					within = append(within, path)
+				}
+			}
+
+			walk(dep.Project, path)
+		}
+	}
+	walk(p, nil)
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return within
+}
+
+func (p *Project) dependencyNames() []string {
+	names := make([]string, 0, len(p.Dependencies))
+	for name := range p.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteText writes the path as indented text, starting at the project named root. Every dependency is listed with its
+// location, the namespace it declares, and the full namespace it's mounted at.
+func (path DependencyPath) WriteText(w io.Writer, root string) error {
+	if _, err := fmt.Fprintln(w, root); err != nil {
+		return err
+	}
+	for i, dep := range path {
+		declared := "no namespace"
+		if dep.Namespace != "" {
+			declared = "namespace " + dep.Namespace
+		}
+		mounted := "not namespaced"
+		if fullNamespace := dep.fullNamespace(); fullNamespace != "" {
+			mounted = "data." + fullNamespace
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %s, %s -> %s\n",
+			strings.Repeat("  ", i+1), dep.Name, dep.Location, declared, mounted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package proj
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindPaths(t *testing.T) {
+	files := map[string]string{
+		"opa.project": `name: proj
+dependencies:
+  foo: file:/foo
+  bar:
+    location: file:/bar
+    namespace: false
+`,
+		filepath.Join(".opa", "dependencies", DepId("foo", "file:/foo"), "opa.project"): `dependencies:
+  no_deps: file:/no_deps`,
+		filepath.Join(".opa", "dependencies", DepId("", "file:/bar"), "opa.project"): `dependencies:
+  no_deps: file:/no_deps`,
+		filepath.Join(".opa", "dependencies", DepId("foo.no_deps", "file:/no_deps"), "policy.rego"): `package foo.no_deps.test`,
+		filepath.Join(".opa", "dependencies", DepId("no_deps", "file:/no_deps"), "policy.rego"):     `package no_deps.test`,
+	}
+
+	tests := []struct {
+		note     string
+		query    string
+		expected string
+	}{
+		{
+			note:  "name",
+			query: "no_deps",
+			expected: `proj
+  bar file:/bar, no namespace -> not namespaced
+    no_deps file:/no_deps, namespace no_deps -> data.no_deps
+proj
+  foo file:/foo, namespace foo -> data.foo
+    no_deps file:/no_deps, namespace no_deps -> data.foo.no_deps
+`,
+		},
+		{
+			note:  "namespace",
+			query: "data.foo",
+			expected: `proj
+  foo file:/foo, namespace foo -> data.foo
+`,
+		},
+		{
+			note:  "package within namespace",
+			query: "data.foo.no_deps.test",
+			expected: `proj
+  foo file:/foo, namespace foo -> data.foo
+    no_deps file:/no_deps, namespace no_deps -> data.foo.no_deps
+`,
+		},
+		{
+			note:  "no match",
+			query: "data.baz",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := withTempFiles(files, func(path string) {
+				project, err := ReadAndLoadProject(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var buf bytes.Buffer
+				for _, p := range project.FindPaths(tc.query) {
+					if err := p.WriteText(&buf, project.Name); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if actual := buf.String(); actual != tc.expected {
+					t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
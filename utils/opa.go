@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"fmt"
 	"github.com/johanfylling/odm/printer"
 	"os"
 )
@@ -80,22 +79,6 @@ func (o *Opa) Build(outputPath string, passThroughFlags ...string) (string, erro
 	return runOpaCommand(o.location, "build", opaArgs...)
 }
 
-func (o *Opa) Refactor(fromPackage, toPackage string) error {
-	printer.Info("Running OPA refactor")
-	printer.Debug("From package: %s", fromPackage)
-	printer.Debug("To package: %s", toPackage)
-
-	mapping := fmt.Sprintf("%s:%s", fromPackage, toPackage)
-
-	opaArgs := make([]string, 0, 4+len(o.dataLocations))
-	opaArgs = append(opaArgs, "move")
-	opaArgs = append(opaArgs, o.dataLocations...)
-	opaArgs = append(opaArgs, "-w", "-p", mapping)
-
-	_, err := runOpaCommand(o.location, "refactor", opaArgs...)
-	return err
-}
-
 func runOpaCommand(opaLocation string, command string, flags ...string) (string, error) {
 	opaArgs := make([]string, 0, 1+len(flags))
 	opaArgs = append(opaArgs, command)
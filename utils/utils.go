@@ -2,8 +2,11 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"github.com/johanfylling/odm/printer"
+	"io/fs"
 	"net/url"
 	"os"
 	"os/exec"
@@ -82,7 +85,7 @@ func NormalizeFilePath(path string) (string, error) {
 	return path, nil
 }
 
-func CopyAll(src string, dstDir string, exclude []string, ignoreEmptyFiles bool) error {
+func CopyAll(ctx context.Context, src string, dstDir string, exclude []string) error {
 	if !FileExists(src) {
 		return fmt.Errorf("source file/directory %s does not exist", src)
 	}
@@ -96,7 +99,7 @@ func CopyAll(src string, dstDir string, exclude []string, ignoreEmptyFiles bool)
 		return fmt.Errorf("failed to stat source file/directory %s: %w", src, err)
 	}
 	if info.IsDir() {
-		printer.Debug("Copying directory", src, "to", dstDir)
+		printer.From(ctx).Debug("Copying directory %s to %s", src, dstDir)
 		children, err := os.ReadDir(src)
 		if err != nil {
 			return fmt.Errorf("failed to read directory %s: %w", src, err)
@@ -104,7 +107,7 @@ func CopyAll(src string, dstDir string, exclude []string, ignoreEmptyFiles bool)
 
 		for _, child := range children {
 			if contains(exclude, child.Name()) {
-				printer.Debug("Skipping excluded file", child.Name())
+				printer.From(ctx).Debug("Skipping excluded file %s", child.Name())
 				continue
 			}
 
@@ -115,21 +118,17 @@ func CopyAll(src string, dstDir string, exclude []string, ignoreEmptyFiles bool)
 				dst = dstDir
 			}
 
-			if err := CopyAll(src+"/"+child.Name(), dst, exclude, ignoreEmptyFiles); err != nil {
+			if err := CopyAll(ctx, src+"/"+child.Name(), dst, exclude); err != nil {
 				return err
 			}
 		}
 	} else {
 		dstFile := dstDir + "/" + info.Name()
-		printer.Debug("Copying file %s to %s", src, dstFile)
+		printer.From(ctx).Debug("Copying file %s to %s", src, dstFile)
 		data, err := os.ReadFile(src)
 		if err != nil {
 			return fmt.Errorf("failed to read file %s: %w", src, err)
 		}
-		if ignoreEmptyFiles && len(data) == 0 {
-			printer.Debug("Skipping empty file", src)
-			return nil
-		}
 		if err := os.WriteFile(dstFile, data, 0644); err != nil {
 			return fmt.Errorf("failed to write file %s: %w", dstFile, err)
 		}
@@ -138,6 +137,42 @@ func CopyAll(src string, dstDir string, exclude []string, ignoreEmptyFiles bool)
 	return nil
 }
 
+// HashDir computes a digest over the relative paths and contents of all files in the dir directory tree.
+// Files and directories with a name in exclude are skipped.
+func HashDir(dir string, exclude []string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && contains(exclude, entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fileHash := sha256.Sum256(data)
+		_, _ = fmt.Fprintf(h, "%s\x00%x\n", filepath.ToSlash(rel), fileHash)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash directory %s: %w", dir, err)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 func contains(arr []string, str string) bool {
 	for _, item := range arr {
 		if item == str {
@@ -148,13 +183,20 @@ func contains(arr []string, str string) bool {
 }
 
 func RunCommand(command string, args ...string) (string, error) {
+	return RunCommandContext(context.Background(), command, args...)
+}
+
+// RunCommandContext is like RunCommand, but kills the command if ctx is done before it completes.
+func RunCommandContext(ctx context.Context, command string, args ...string) (string, error) {
 	printer.Debug("Executing '%s' with args: %s", command, args)
-	cmd := exec.Command(command, args...)
+	cmd := exec.CommandContext(ctx, command, args...)
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
 	if err := cmd.Run(); err != nil {
-		if errb.Len() != 0 {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("%s: %w", command, ctxErr)
+		} else if errb.Len() != 0 {
 			return "", fmt.Errorf("%s", errb.String())
 		} else {
 			return "", fmt.Errorf("%s", outb.String())